@@ -3,56 +3,242 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/coocood/freecache"
+	"github.com/gomodule/redigo/redis"
 )
 
 const (
-	defaultCacheSize = 1000 << 20  // 1000 MB cache
-	defaultExpiry    = 120 * 3600  // 120 hours cache expiry
-	stringSeparator  = "<>"        // Assuming the separator wont be used in any case.
+	defaultCacheSize = 1000       // 1000 MB cache
+	defaultExpiry    = 120 * 3600 // 120 hours cache expiry
+	stringSeparator  = "<>"       // Assuming the separator wont be used in any case.
+
+	cacheBackendFreecache = "freecache"
+	cacheBackendRedis     = "redis"
+
+	// All keys in a shared Redis are prefixed with this.
+	redisKeyPrefix = "varnamd:"
+
+	// Generations read from Redis are used for this long before they are read again.
+	redisGenerationTTL = time.Second
+
+	// Keys which can't be cached, as the generation of their scheme couldn't
+	// be read, start with this. They are never stored or found.
+	uncachedKeyPrefix = "!"
 )
 
-// Cache objects.
-type Cache struct {
-	fc *freecache.Cache
+var errCacheMiss = errors.New("cache miss")
+
+// Cache stores the results of the endpoints. Keys of a scheme are built with
+// Key so that they can be invalidated together.
+type Cache interface {
+	// Key builds the cache key of a scheme, like tl-ml-0-word.
+	Key(prefix string, scheme string, parts ...string) string
+
+	SetString(key string, val ...string) error
+	GetString(key string) ([]string, error)
+	Set(key string, value interface{}) error
+	Get(key string) (interface{}, error)
+	Delete(key string) (bool, error)
+
+	// Has tells if a key is cached without counting it as a lookup or a hit.
+	Has(key string) bool
+
+	// Clear everything in the cache.
+	Clear()
+
+	// InvalidateScheme makes the entries of a scheme unreachable.
+	InvalidateScheme(scheme string)
+}
+
+// cacheConfig is the [cache] section of the config.
+type cacheConfig struct {
+	Enabled bool   `koanf:"enabled"`
+	Backend string `koanf:"backend"`
+
+	// Size of the freecache in MB.
+	Size int `koanf:"size"`
+
+	// Expiry of the entries by the key prefix (tl, atl, rtl), or default.
+	TTL map[string]time.Duration `koanf:"ttl"`
+
+	// Hot entries of a local cache are saved to disk at this interval
+	// and loaded at boot. 0 disables snapshots.
+	SnapshotInterval time.Duration `koanf:"snapshot-interval"`
+	SnapshotSize     int           `koanf:"snapshot-size"`
+
+	RedisAddress  string `koanf:"redis-address"`
+	RedisPassword string `koanf:"redis-password"`
+	RedisDB       int    `koanf:"redis-db"`
 }
 
 type GeneralCacheItem struct {
 	Value interface{}
 }
 
-// NewCache will create a new freecache
-func NewCache() Cache {
-	return Cache{fc: freecache.NewCache(defaultCacheSize)}
+// NewCache creates the cache configured. A disabled cache stores nothing.
+func NewCache(cfg cacheConfig) (Cache, error) {
+	if !cfg.Enabled {
+		return noopCache{}, nil
+	}
+
+	switch cfg.Backend {
+	case "", cacheBackendFreecache:
+		size := cfg.Size
+		if size <= 0 {
+			size = defaultCacheSize
+		}
+
+		return &freeCache{
+			fc:            freecache.NewCache(size << 20),
+			ttl:           cfg.TTL,
+			generations:   make(map[string]uint64),
+			generationsMu: &sync.RWMutex{},
+			hits:          make(map[string]uint32),
+			hitsMu:        &sync.Mutex{},
+			counters:      newCacheCounters(),
+		}, nil
+
+	case cacheBackendRedis:
+		if cfg.RedisAddress == "" {
+			return nil, errors.New("cache.redis-address is required for the redis cache")
+		}
+
+		return newRedisCache(cfg), nil
+	}
+
+	return nil, fmt.Errorf("unknown cache backend: %s", cfg.Backend)
 }
 
-// Set a string value to cache.
-func (c *Cache) SetString(key string, val ...string) error {
-	var value = strings.Join(val, stringSeparator)
-	return c.setWithExpiry(key, []byte(value), int(defaultExpiry))
+// Least time between two invalidations of the learnings of a language.
+const learningsInvalidateInterval = 5 * time.Second
+
+var (
+	learningsInvalidationsMu sync.Mutex
+	// Last invalidation of the learnings of each language.
+	lastLearningsInvalidations = make(map[string]time.Time)
+	// Languages with an invalidation waiting for the interval to pass.
+	pendingLearningsInvalidations = make(map[string]bool)
+)
+
+// invalidateLearnings is the invalidation policy for writes to the learnings of a scheme.
+// Every path that learns, trains, imports or deletes words calls it once the write
+// is applied. Learned words show up in the results of other words too, like the
+// suggestions of their prefixes, so all the entries are invalidated. Schemes of
+// a language share the learnings, so the entries of all of them are invalidated.
+//
+// So that a stream of writes doesn't keep the cache cold, a language is invalidated
+// at most once every learningsInvalidateInterval. Writes within the interval of the
+// last invalidation are invalidated together once the interval has passed.
+func invalidateLearnings(c Cache, scheme string) {
+	lang := learningsLangCode(scheme)
+
+	learningsInvalidationsMu.Lock()
+
+	if pendingLearningsInvalidations[lang] {
+		learningsInvalidationsMu.Unlock()
+		return
+	}
+
+	wait := learningsInvalidateInterval - time.Since(lastLearningsInvalidations[lang])
+	if wait <= 0 {
+		lastLearningsInvalidations[lang] = time.Now()
+		learningsInvalidationsMu.Unlock()
+
+		invalidateLanguage(c, scheme)
+
+		return
+	}
+
+	pendingLearningsInvalidations[lang] = true
+	learningsInvalidationsMu.Unlock()
+
+	time.AfterFunc(wait, func() {
+		learningsInvalidationsMu.Lock()
+		delete(pendingLearningsInvalidations, lang)
+		lastLearningsInvalidations[lang] = time.Now()
+		learningsInvalidationsMu.Unlock()
+
+		invalidateLanguage(c, scheme)
+	})
 }
 
-func (c *Cache) setWithExpiry(key string, val []byte, expiry int) error {
-	return c.fc.Set([]byte(key), val, expiry)
+// invalidatePattern removes the tl- and atl- results of a pattern of the schemes
+// of a language right away. Training and unlearning a pattern call it along with
+// invalidateLearnings so that the pattern is right before the next invalidation.
+func invalidatePattern(c Cache, scheme string, pattern string) {
+	lang := learningsLangCode(scheme)
+
+	for _, sd := range schemeDetails {
+		if sd.Identifier == scheme || sd.LangCode == lang {
+			_, _ = c.Delete(c.Key("tl", sd.Identifier, pattern))
+			_, _ = c.Delete(c.Key("atl", sd.Identifier, pattern))
+		}
+	}
 }
 
-// Get a string value from cache.
-func (c *Cache) GetString(key string) ([]string, error) {
-	val, err := c.fc.Get([]byte(key))
-	if err != nil {
-		return nil, err
+// invalidateLanguage invalidates the entries of the schemes of the language of a scheme.
+func invalidateLanguage(c Cache, scheme string) {
+	lang := learningsLangCode(scheme)
+
+	for _, sd := range schemeDetails {
+		if sd.Identifier == scheme || sd.LangCode == lang {
+			c.InvalidateScheme(sd.Identifier)
+		}
 	}
+}
 
-	return strings.Split(string(val), stringSeparator), nil
+// learningsLangCode returns the language whose learnings a scheme uses, or the scheme itself if it's unknown.
+func learningsLangCode(scheme string) string {
+	if lang, ok := schemeLangCode(scheme); ok {
+		return lang
+	}
+
+	return scheme
 }
 
-// Set a generic cache value.
+// isUncachedKey tells if a key was built without the generation of its scheme.
+func isUncachedKey(key string) bool {
+	return strings.HasPrefix(key, uncachedKeyPrefix)
+}
+
+func buildCacheKey(prefix string, scheme string, generation uint64, parts []string) string {
+	return fmt.Sprintf("%s-%s-%d-%s", prefix, scheme, generation, strings.Join(parts, "-"))
+}
+
+// cacheExpiry returns the expiry in seconds of a key from its prefix.
+func cacheExpiry(ttl map[string]time.Duration, key string) int {
+	prefix := cacheKeyPrefix(key)
+
+	if d, ok := ttl[prefix]; ok && d > 0 {
+		return int(d / time.Second)
+	}
+
+	if d, ok := ttl["default"]; ok && d > 0 {
+		return int(d / time.Second)
+	}
+
+	return defaultExpiry
+}
+
+func cacheKeyPrefix(key string) string {
+	if i := strings.IndexByte(key, '-'); i >= 0 {
+		return key[:i]
+	}
+
+	return key
+}
+
+// encodeCacheItem encodes a generic cache value.
 // These are about 100 micro-seconds (3 times) slower
-// than the string cache above. It's slower because of
+// than the string cache. It's slower because of
 // encoding and decoding
-func (c *Cache) Set(key string, value interface{}) error {
+func encodeCacheItem(value interface{}) ([]byte, error) {
 	var valueBytesBuffer bytes.Buffer
 
 	// gob can only encode struct items.
@@ -60,39 +246,430 @@ func (c *Cache) Set(key string, value interface{}) error {
 	cacheItem := GeneralCacheItem{value}
 
 	enc := gob.NewEncoder(&valueBytesBuffer)
-	err := enc.Encode(cacheItem)
+	if err := enc.Encode(cacheItem); err != nil {
+		return nil, err
+	}
+
+	return valueBytesBuffer.Bytes(), nil
+}
+
+func decodeCacheItem(valueBytes []byte) (interface{}, error) {
+	var cacheItem GeneralCacheItem
+
+	dec := gob.NewDecoder(bytes.NewReader(valueBytes))
+	if err := dec.Decode(&cacheItem); err != nil {
+		return nil, err
+	}
+
+	return cacheItem.Value, nil
+}
+
+// freeCache is an in-memory cache local to the process.
+type freeCache struct {
+	fc  *freecache.Cache
+	ttl map[string]time.Duration
+
+	// Generation of each scheme, part of the keys of the scheme.
+	generations   map[string]uint64
+	generationsMu *sync.RWMutex
+
+	// Generations shared by the peers of a cluster, used instead of
+	// generations so that invalidations reach every peer.
+	shared *redisGenerations
+
+	// Hits of the keys, for snapshotting the hot entries.
+	hits   map[string]uint32
+	hitsMu *sync.Mutex
+
+	// Lookups of each key namespace.
+	counters *cacheCounters
+}
+
+func (c *freeCache) Key(prefix string, scheme string, parts ...string) string {
+	if c.shared != nil {
+		return c.shared.key(prefix, scheme, parts)
+	}
+
+	c.generationsMu.RLock()
+	gen := c.generations[scheme]
+	c.generationsMu.RUnlock()
+
+	return buildCacheKey(prefix, scheme, gen, parts)
+}
+
+// InvalidateScheme bumps the generation of the scheme. Old entries are
+// left to be evicted by freecache instead of clearing the whole cache.
+func (c *freeCache) InvalidateScheme(scheme string) {
+	if c.shared != nil {
+		c.shared.incr(scheme)
+		return
+	}
+
+	c.generationsMu.Lock()
+	c.generations[scheme]++
+	c.generationsMu.Unlock()
+}
+
+// Set a string value to cache.
+func (c *freeCache) SetString(key string, val ...string) error {
+	if isUncachedKey(key) {
+		return nil
+	}
+
+	var value = strings.Join(val, stringSeparator)
+	return c.fc.Set([]byte(key), []byte(value), cacheExpiry(c.ttl, key))
+}
+
+// Get a string value from cache.
+func (c *freeCache) GetString(key string) ([]string, error) {
+	start := time.Now()
+
+	val, err := c.fc.Get([]byte(key))
+	c.counters.recordLookup(key, start, err == nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.recordHit(key)
+
+	return strings.Split(string(val), stringSeparator), nil
+}
+
+// Set a generic cache value.
+func (c *freeCache) Set(key string, value interface{}) error {
+	if isUncachedKey(key) {
+		return nil
+	}
+
+	b, err := encodeCacheItem(value)
 	if err != nil {
 		return err
 	}
 
-	return c.fc.Set([]byte(key), valueBytesBuffer.Bytes(), int(defaultExpiry))
+	return c.fc.Set([]byte(key), b, cacheExpiry(c.ttl, key))
 }
 
 // Get a value from cache.
-func (c *Cache) Get(key string) (interface{}, error) {
+func (c *freeCache) Get(key string) (interface{}, error) {
+	start := time.Now()
+
 	valueBytes, err := c.fc.Get([]byte(key))
+	c.counters.recordLookup(key, start, err == nil)
+
 	if err != nil {
 		return nil, err
 	}
 
-	var cacheItem GeneralCacheItem
+	c.recordHit(key)
 
-	dec := gob.NewDecoder(bytes.NewReader(valueBytes))
-	err = dec.Decode(&cacheItem)
+	return decodeCacheItem(valueBytes)
+}
+
+// Delete a value from cache.
+func (c *freeCache) Delete(key string) (bool, error) {
+	affected := c.fc.Del([]byte(key))
+	return affected, nil
+}
+
+func (c *freeCache) Has(key string) bool {
+	// Unlike Get, TTL doesn't touch the entry or the stats of freecache
+	_, err := c.fc.TTL([]byte(key))
+	return err == nil
+}
+
+// Clear everything in the cache. In a cluster, the generations are bumped
+// too, as the results sharded across the peers are held under them.
+func (c *freeCache) Clear() {
+	c.fc.Clear()
+
+	if c.shared != nil {
+		for _, sd := range schemeDetails {
+			c.shared.incr(sd.Identifier)
+		}
+	}
+}
+
+// noopCache is used when the cache is disabled. Every lookup is a miss.
+type noopCache struct{}
+
+func (noopCache) Key(prefix string, scheme string, parts ...string) string {
+	return buildCacheKey(prefix, scheme, 0, parts)
+}
+
+func (noopCache) SetString(key string, val ...string) error { return nil }
+
+func (noopCache) GetString(key string) ([]string, error) { return nil, errCacheMiss }
+
+func (noopCache) Set(key string, value interface{}) error { return nil }
+
+func (noopCache) Get(key string) (interface{}, error) { return nil, errCacheMiss }
+
+func (noopCache) Delete(key string) (bool, error) { return false, nil }
+
+func (noopCache) Has(key string) bool { return false }
+
+func (noopCache) Clear() {}
+
+func (noopCache) InvalidateScheme(scheme string) {}
+
+// redisGenerations are the generations of the schemes kept in a server speaking
+// the Redis protocol, so that an invalidation by one replica applies to all.
+type redisGenerations struct {
+	pool *redis.Pool
+
+	// Generations read from the server, so that building
+	// a key doesn't need a round trip every time.
+	generations map[string]redisGeneration
+	mu          *sync.Mutex
+	ttl         time.Duration
+}
+
+type redisGeneration struct {
+	gen    uint64
+	readAt time.Time
+}
+
+func newRedisPool(address string, password string, db int) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address,
+				redis.DialPassword(password),
+				redis.DialDatabase(db),
+				redis.DialConnectTimeout(2*time.Second),
+				redis.DialReadTimeout(2*time.Second),
+				redis.DialWriteTimeout(2*time.Second),
+			)
+		},
+	}
+}
+
+func newRedisGenerations(pool *redis.Pool) *redisGenerations {
+	return &redisGenerations{
+		pool:        pool,
+		generations: make(map[string]redisGeneration),
+		mu:          &sync.Mutex{},
+		ttl:         redisGenerationTTL,
+	}
+}
+
+func (g *redisGenerations) do(cmd string, args ...interface{}) (interface{}, error) {
+	conn := g.pool.Get()
+	defer func() { _ = conn.Close() }()
+
+	return conn.Do(cmd, args...)
+}
+
+// key builds the key with the generation of the scheme in the server, which is
+// read again once it's older than ttl. If it can't be read, the key is uncached
+// so that the lookup skips the cache instead of finding stale entries.
+func (g *redisGenerations) key(prefix string, scheme string, parts []string) string {
+	gen, err := g.get(scheme)
+	if err != nil {
+		return uncachedKeyPrefix + buildCacheKey(prefix, scheme, 0, parts)
+	}
+
+	return buildCacheKey(prefix, scheme, gen, parts)
+}
+
+func (g *redisGenerations) get(scheme string) (uint64, error) {
+	g.mu.Lock()
+	r, ok := g.generations[scheme]
+	g.mu.Unlock()
+
+	if ok && time.Since(r.readAt) < g.ttl {
+		return r.gen, nil
+	}
+
+	gen, err := redis.Uint64(g.do("GET", redisKeyPrefix+"gen:"+scheme))
+	if err == redis.ErrNil {
+		gen, err = 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	g.set(scheme, gen)
+
+	return gen, nil
+}
+
+func (g *redisGenerations) set(scheme string, gen uint64) {
+	g.mu.Lock()
+	g.generations[scheme] = redisGeneration{gen: gen, readAt: time.Now()}
+	g.mu.Unlock()
+}
+
+// incr bumps the generation of the scheme in the server. Other
+// replicas see it once the generation they have is older than ttl.
+func (g *redisGenerations) incr(scheme string) {
+	gen, err := redis.Uint64(g.do("INCR", redisKeyPrefix+"gen:"+scheme))
+	if err != nil {
+		// Read again on the next key
+		g.mu.Lock()
+		delete(g.generations, scheme)
+		g.mu.Unlock()
+
+		return
+	}
+
+	g.set(scheme, gen)
+}
+
+// redisCache is a cache shared by varnamd replicas through a server
+// speaking the Redis protocol. Scheme generations are kept in the
+// server too, so an invalidation by one replica applies to all.
+type redisCache struct {
+	pool        *redis.Pool
+	ttl         map[string]time.Duration
+	generations *redisGenerations
+}
+
+func newRedisCache(cfg cacheConfig) *redisCache {
+	pool := newRedisPool(cfg.RedisAddress, cfg.RedisPassword, cfg.RedisDB)
+
+	return &redisCache{
+		pool:        pool,
+		ttl:         cfg.TTL,
+		generations: newRedisGenerations(pool),
+	}
+}
+
+func (c *redisCache) do(cmd string, args ...interface{}) (interface{}, error) {
+	conn := c.pool.Get()
+	defer func() { _ = conn.Close() }()
+
+	return conn.Do(cmd, args...)
+}
+
+func (c *redisCache) Key(prefix string, scheme string, parts ...string) string {
+	return c.generations.key(prefix, scheme, parts)
+}
+
+func (c *redisCache) InvalidateScheme(scheme string) {
+	c.generations.incr(scheme)
+}
+
+func (c *redisCache) set(key string, val []byte) error {
+	if isUncachedKey(key) {
+		return nil
+	}
+
+	_, err := c.do("SET", redisKeyPrefix+key, val, "EX", cacheExpiry(c.ttl, key))
+	return err
+}
+
+func (c *redisCache) get(key string) ([]byte, error) {
+	if isUncachedKey(key) {
+		return nil, errCacheMiss
+	}
+
+	val, err := redis.Bytes(c.do("GET", redisKeyPrefix+key))
+	if err == redis.ErrNil {
+		return nil, errCacheMiss
+	}
+
+	return val, err
+}
+
+// Set a string value to cache.
+func (c *redisCache) SetString(key string, val ...string) error {
+	return c.set(key, []byte(strings.Join(val, stringSeparator)))
+}
+
+// Get a string value from cache.
+func (c *redisCache) GetString(key string) ([]string, error) {
+	val, err := c.get(key)
 	if err != nil {
 		return nil, err
 	}
 
-	return cacheItem.Value, nil
+	return strings.Split(string(val), stringSeparator), nil
+}
+
+// Set a generic cache value.
+func (c *redisCache) Set(key string, value interface{}) error {
+	b, err := encodeCacheItem(value)
+	if err != nil {
+		return err
+	}
+
+	return c.set(key, b)
+}
+
+// Get a value from cache.
+func (c *redisCache) Get(key string) (interface{}, error) {
+	val, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeCacheItem(val)
 }
 
 // Delete a value from cache.
-func (c *Cache) Delete(key string) (bool, error) {
-	affected := c.fc.Del([]byte(key))
-	return affected, nil
+func (c *redisCache) Delete(key string) (bool, error) {
+	if isUncachedKey(key) {
+		return false, nil
+	}
+
+	n, err := redis.Int(c.do("DEL", redisKeyPrefix+key))
+	return n > 0, err
 }
 
-// Clear everything in the cache.
-func (c *Cache) Clear() {
-	c.fc.Clear()
+func (c *redisCache) Has(key string) bool {
+	if isUncachedKey(key) {
+		return false
+	}
+
+	n, err := redis.Int(c.do("EXISTS", redisKeyPrefix+key))
+	return err == nil && n > 0
+}
+
+// Clear removes the keys of varnamd, leaving the rest of the server alone.
+func (c *redisCache) Clear() {
+	c.clear()
+
+	// Results sharded across a cluster are held under the generations
+	for _, sd := range schemeDetails {
+		c.InvalidateScheme(sd.Identifier)
+	}
+}
+
+// clear removes the keys of varnamd but the generations, which only go up.
+func (c *redisCache) clear() {
+	conn := c.pool.Get()
+	defer func() { _ = conn.Close() }()
+
+	cursor := 0
+
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", redisKeyPrefix+"*", "COUNT", 1000))
+		if err != nil {
+			return
+		}
+
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return
+		}
+
+		var args []interface{}
+		for _, k := range keys {
+			if !strings.HasPrefix(k, redisKeyPrefix+"gen:") {
+				args = append(args, k)
+			}
+		}
+
+		if len(args) > 0 {
+			_, _ = conn.Do("DEL", args...)
+		}
+
+		if cursor == 0 {
+			return
+		}
+	}
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Entries are counted by namespace by walking the whole cache,
+// so the counts are reused for this long.
+const cacheEntryCountInterval = time.Minute
+
+// cacheNamespaces are the key prefixes stats are kept for.
+// Keys of any other prefix are counted under "other".
+var cacheNamespaces = []string{"tl", "atl", "rtl"}
+
+// cacheStatsReporter is implemented by caches which keep stats.
+type cacheStatsReporter interface {
+	Stats() cacheStats
+}
+
+type cacheStats struct {
+	Backend string `json:"backend"`
+	Entries int64  `json:"entries"`
+	Hits    int64  `json:"hits"`
+	Misses  int64  `json:"misses"`
+	// Entries removed to make room for others, before they expired.
+	Evictions int64   `json:"evictions"`
+	Expired   int64   `json:"expired"`
+	HitRate   float64 `json:"hit_rate"`
+
+	Namespaces map[string]namespaceStats `json:"namespaces"`
+}
+
+type namespaceStats struct {
+	Entries int64   `json:"entries"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+	// Average time taken by a lookup, in microseconds.
+	AverageAccessTime float64 `json:"average_access_time"`
+}
+
+// namespaceCounter counts the lookups of a namespace.
+// Updated atomically, so the fields are kept 64-bit aligned.
+type namespaceCounter struct {
+	hits       int64
+	misses     int64
+	accessTime int64 // nanoseconds, of all lookups
+}
+
+type cacheCounters struct {
+	namespaces map[string]*namespaceCounter
+
+	entriesMu sync.Mutex
+	entries   map[string]int64
+	countedAt time.Time
+}
+
+func newCacheCounters() *cacheCounters {
+	c := &cacheCounters{namespaces: make(map[string]*namespaceCounter)}
+
+	for _, ns := range cacheNamespaces {
+		c.namespaces[ns] = &namespaceCounter{}
+	}
+
+	c.namespaces["other"] = &namespaceCounter{}
+
+	return c
+}
+
+// cacheNamespace returns the namespace of a key from its prefix.
+func cacheNamespace(key string) string {
+	prefix := cacheKeyPrefix(key)
+
+	for _, ns := range cacheNamespaces {
+		if ns == prefix {
+			return ns
+		}
+	}
+
+	return "other"
+}
+
+// isCacheNamespace tells if ns is exactly one of the namespaces.
+func isCacheNamespace(ns string) bool {
+	for _, n := range cacheNamespaces {
+		if n == ns {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordLookup counts a lookup of a key which started at start.
+func (c *cacheCounters) recordLookup(key string, start time.Time, hit bool) {
+	n := c.namespaces[cacheNamespace(key)]
+
+	atomic.AddInt64(&n.accessTime, int64(time.Since(start)))
+
+	if hit {
+		atomic.AddInt64(&n.hits, 1)
+	} else {
+		atomic.AddInt64(&n.misses, 1)
+	}
+}
+
+// namespaceStats returns the stats of every namespace. Entries are
+// counted with count when the last count is too old.
+func (c *cacheCounters) namespaceStats(count func() map[string]int64) map[string]namespaceStats {
+	c.entriesMu.Lock()
+	if c.entries == nil || time.Since(c.countedAt) > cacheEntryCountInterval {
+		c.entries = count()
+		c.countedAt = time.Now()
+	}
+	entries := c.entries
+	c.entriesMu.Unlock()
+
+	stats := make(map[string]namespaceStats, len(c.namespaces))
+
+	for ns, n := range c.namespaces {
+		s := namespaceStats{
+			Entries: entries[ns],
+			Hits:    atomic.LoadInt64(&n.hits),
+			Misses:  atomic.LoadInt64(&n.misses),
+		}
+
+		if lookups := s.Hits + s.Misses; lookups > 0 {
+			s.HitRate = float64(s.Hits) / float64(lookups)
+			s.AverageAccessTime = float64(atomic.LoadInt64(&n.accessTime)) / float64(lookups) / float64(time.Microsecond)
+		}
+
+		stats[ns] = s
+	}
+
+	return stats
+}
+
+// Stats returns the stats of freecache along with the stats of each namespace.
+func (c *freeCache) Stats() cacheStats {
+	return cacheStats{
+		Backend:   cacheBackendFreecache,
+		Entries:   c.fc.EntryCount(),
+		Hits:      c.fc.HitCount(),
+		Misses:    c.fc.MissCount(),
+		Evictions: c.fc.EvacuateCount(),
+		Expired:   c.fc.ExpiredCount(),
+		HitRate:   c.fc.HitRate(),
+
+		Namespaces: c.counters.namespaceStats(c.countEntries),
+	}
+}
+
+// countEntries counts the entries of each namespace, including the
+// entries of old generations which haven't been evicted yet.
+func (c *freeCache) countEntries() map[string]int64 {
+	entries := make(map[string]int64)
+
+	it := c.fc.NewIterator()
+	for e := it.Next(); e != nil; e = it.Next() {
+		entries[cacheNamespace(string(e.Key))]++
+	}
+
+	return entries
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisCache(t *testing.T, srv *miniredis.Miniredis) *redisCache {
+	t.Helper()
+
+	c, err := NewCache(cacheConfig{Enabled: true, Backend: cacheBackendRedis, RedisAddress: srv.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c.(*redisCache)
+}
+
+func TestRedisCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	c := newTestRedisCache(t, srv)
+
+	key := c.Key("tl", "ml", "mala")
+	if key != "tl-ml-0-mala" {
+		t.Fatalf("unexpected key %s", key)
+	}
+
+	if _, err := c.GetString(key); err != errCacheMiss {
+		t.Fatalf("expected a miss, got %v", err)
+	}
+
+	if err := c.SetString(key, "മല", "മാല"); err != nil {
+		t.Fatal(err)
+	}
+
+	words, err := c.GetString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(words) != 2 || words[0] != "മല" || words[1] != "മാല" {
+		t.Fatalf("unexpected value %v", words)
+	}
+
+	if !c.Has(key) {
+		t.Fatalf("expected %s to be cached", key)
+	}
+
+	if !srv.Exists(redisKeyPrefix + key) {
+		t.Fatalf("%s isn't stored with the prefix", key)
+	}
+
+	if ttl := srv.TTL(redisKeyPrefix + key); ttl != defaultExpiry*time.Second {
+		t.Fatalf("unexpected expiry %s", ttl)
+	}
+
+	item := []suggestionResponse{{Word: "മല", Weight: 10}}
+	if err := c.Set(c.Key("atl", "ml", "mala"), item); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.Get(c.Key("atl", "ml", "mala"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sugs := got.([]suggestionResponse); len(sugs) != 1 || sugs[0] != item[0] {
+		t.Fatalf("unexpected value %v", got)
+	}
+
+	deleted, err := c.Delete(key)
+	if err != nil || !deleted {
+		t.Fatalf("expected %s to be deleted, got %v, %v", key, deleted, err)
+	}
+
+	if err := c.SetString(key, "മല"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Clear()
+
+	// Generations are kept and moved on, so that results held elsewhere under them aren't found
+	for _, k := range srv.Keys() {
+		if !strings.HasPrefix(k, redisKeyPrefix+"gen:") {
+			t.Fatalf("expected only generations after clear, got %v", srv.Keys())
+		}
+	}
+
+	if got := c.Key("tl", "ml", "mala"); got == key {
+		t.Fatalf("expected the generation to move on after clear, got %s", got)
+	}
+}
+
+// An invalidation by a replica applies to the others once their generations are read again.
+func TestRedisCacheInvalidateScheme(t *testing.T) {
+	var (
+		srv     = miniredis.RunT(t)
+		c       = newTestRedisCache(t, srv)
+		replica = newTestRedisCache(t, srv)
+	)
+
+	if err := c.SetString(c.Key("tl", "ml", "mala"), "മല"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The generation is read by the replica before the invalidation
+	replica.Key("tl", "ml", "mala")
+
+	c.InvalidateScheme("ml")
+
+	if key := c.Key("tl", "ml", "mala"); key != "tl-ml-1-mala" {
+		t.Fatalf("unexpected key after invalidation %s", key)
+	}
+
+	if _, err := c.GetString(c.Key("tl", "ml", "mala")); err != errCacheMiss {
+		t.Fatalf("expected a miss after invalidation, got %v", err)
+	}
+
+	if key := replica.Key("tl", "ml", "mala"); key != "tl-ml-0-mala" {
+		t.Fatalf("expected the generation read before, got %s", key)
+	}
+
+	replica.generations.ttl = 0
+
+	if key := replica.Key("tl", "ml", "mala"); key != "tl-ml-1-mala" {
+		t.Fatalf("expected the generation to be read again, got %s", key)
+	}
+}
+
+// Keys are not cached when the generation can't be read.
+func TestRedisCacheUnreachable(t *testing.T) {
+	srv := miniredis.RunT(t)
+	c := newTestRedisCache(t, srv)
+	c.generations.ttl = 0
+
+	srv.SetError("server is down")
+
+	key := c.Key("tl", "ml", "mala")
+	if !isUncachedKey(key) {
+		t.Fatalf("expected an uncached key, got %s", key)
+	}
+
+	srv.SetError("")
+
+	if err := c.SetString(key, "മല"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.GetString(key); err != errCacheMiss {
+		t.Fatalf("expected a miss for an uncached key, got %v", err)
+	}
+
+	if len(srv.Keys()) != 0 {
+		t.Fatalf("expected nothing to be stored, got %v", srv.Keys())
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/varnamproject/govarnam/govarnamgo"
+)
+
+const (
+	defaultSnapshotInterval = 10 * time.Minute
+	defaultSnapshotSize     = 10000
+
+	// Number of keys whose hits are counted for picking the hot entries.
+	maxTrackedHits = 100000
+
+	// Number of patterns accepted by a warm request.
+	maxWarmPatterns = 10000
+)
+
+// cacheSnapshotter is implemented by caches local to the process,
+// which are empty after a restart.
+type cacheSnapshotter interface {
+	// Snapshot writes up to limit of the most hit entries.
+	Snapshot(w io.Writer, limit int) (int, error)
+	// Restore loads the entries of a snapshot which haven't expired.
+	Restore(r io.Reader) (int, error)
+}
+
+type cacheSnapshot struct {
+	Generations map[string]uint64
+	Entries     []cacheSnapshotEntry
+}
+
+type cacheSnapshotEntry struct {
+	Key   string
+	Value []byte
+	// Unix time the entry expires at, 0 if never.
+	ExpireAt uint32
+}
+
+// recordHit counts a hit of a key. New keys are not counted
+// once maxTrackedHits keys are tracked.
+func (c *freeCache) recordHit(key string) {
+	c.hitsMu.Lock()
+	defer c.hitsMu.Unlock()
+
+	if _, ok := c.hits[key]; ok || len(c.hits) < maxTrackedHits {
+		c.hits[key]++
+	}
+}
+
+// Snapshot writes the most hit entries along with the scheme generations, so that
+// the keys are still valid when restored. Hit counts are halved after every
+// snapshot so that entries which are no longer hit make room for others.
+func (c *freeCache) Snapshot(w io.Writer, limit int) (int, error) {
+	c.hitsMu.Lock()
+
+	keys := make([]string, 0, len(c.hits))
+	for k := range c.hits {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return c.hits[keys[i]] > c.hits[keys[j]]
+	})
+
+	for k, n := range c.hits {
+		if n /= 2; n == 0 {
+			delete(c.hits, k)
+		} else {
+			c.hits[k] = n
+		}
+	}
+
+	c.hitsMu.Unlock()
+
+	snapshot := cacheSnapshot{Generations: make(map[string]uint64)}
+
+	c.generationsMu.RLock()
+	for scheme, gen := range c.generations {
+		snapshot.Generations[scheme] = gen
+	}
+	c.generationsMu.RUnlock()
+
+	for _, key := range keys {
+		if len(snapshot.Entries) >= limit {
+			break
+		}
+
+		// Evicted or expired since
+		val, expireAt, err := c.fc.GetWithExpiration([]byte(key))
+		if err != nil {
+			continue
+		}
+
+		snapshot.Entries = append(snapshot.Entries, cacheSnapshotEntry{Key: key, Value: val, ExpireAt: expireAt})
+	}
+
+	return len(snapshot.Entries), gob.NewEncoder(w).Encode(snapshot)
+}
+
+// Restore loads a snapshot. It's meant to be used at boot, before the cache is used.
+func (c *freeCache) Restore(r io.Reader) (int, error) {
+	var snapshot cacheSnapshot
+	if err := gob.NewDecoder(r).Decode(&snapshot); err != nil {
+		return 0, err
+	}
+
+	c.generationsMu.Lock()
+	for scheme, gen := range snapshot.Generations {
+		c.generations[scheme] = gen
+	}
+	c.generationsMu.Unlock()
+
+	var (
+		now   = uint32(time.Now().Unix())
+		count int
+	)
+
+	for _, e := range snapshot.Entries {
+		expiry := 0
+		if e.ExpireAt > 0 {
+			if e.ExpireAt <= now {
+				continue
+			}
+
+			expiry = int(e.ExpireAt - now)
+		}
+
+		if err := c.fc.Set([]byte(e.Key), e.Value, expiry); err != nil {
+			continue
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// saveCacheSnapshot writes a snapshot of the cache to disk.
+// The previous snapshot is replaced only once the new one is written.
+func saveCacheSnapshot(cache Cache, limit int) (int, error) {
+	s, ok := cache.(cacheSnapshotter)
+	if !ok {
+		return 0, nil
+	}
+
+	if err := createCacheDir(); err != nil {
+		return 0, err
+	}
+
+	snapshotPath := getCacheSnapshotPath()
+	tmpPath := snapshotPath + ".tmp"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := s.Snapshot(file, limit)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return 0, err
+	}
+
+	return count, os.Rename(tmpPath, snapshotPath)
+}
+
+// loadCacheSnapshot restores the snapshot saved by the last run, if there's one.
+func loadCacheSnapshot(cache Cache) (int, error) {
+	s, ok := cache.(cacheSnapshotter)
+	if !ok {
+		return 0, nil
+	}
+
+	file, err := os.Open(getCacheSnapshotPath())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	return s.Restore(file)
+}
+
+// snapshotCachePeriodically saves a snapshot of the cache at every interval.
+func (app *App) snapshotCachePeriodically(interval time.Duration, limit int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		count, err := saveCacheSnapshot(app.cache, limit)
+		if err != nil {
+			app.log.Printf("error saving cache snapshot, err: %s", err.Error())
+			continue
+		}
+
+		app.log.Printf("saved %d cache entries to snapshot", count)
+	}
+}
+
+// warmCache precomputes the /tl and /atl results of the patterns, borrowing
+// a handle for each result. Patterns already in the cache are skipped, and
+// checking them isn't counted in the cache stats. It returns the number of
+// patterns computed and the number which failed.
+func warmCache(ctx context.Context, app *App, langCode string, patterns []string) (int, int, error) {
+	var warmed, failed int
+
+	for _, pattern := range patterns {
+		if ctx.Err() != nil {
+			return warmed, failed, ctx.Err()
+		}
+
+		var computed bool
+
+		if !app.cache.Has(app.cache.Key("tl", langCode, pattern)) {
+			_, err := getOrCreateHandler(ctx, langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+				return transliterateAndCache(ctx, app, handle, langCode, pattern)
+			})
+			if err != nil {
+				app.log.Printf("error warming cache, word: %s, err: %s", pattern, err.Error())
+				failed++
+				continue
+			}
+
+			computed = true
+		}
+
+		atlKey := app.cache.Key("atl", langCode, pattern)
+		if !app.cache.Has(atlKey) {
+			result, err := transliterateAdvanced(ctx, langCode, pattern)
+			if err != nil {
+				app.log.Printf("error warming cache, word: %s, err: %s", pattern, err.Error())
+				failed++
+				continue
+			}
+
+			_ = app.cache.Set(atlKey, toAdvancedTransliterationResponse(result.(govarnamgo.TransliterationResult)))
+			computed = true
+		}
+
+		if computed {
+			warmed++
+		}
+	}
+
+	return warmed, failed, nil
+}
+
+func createCacheDir() error {
+	cacheDir := getCacheDir()
+	return os.MkdirAll(cacheDir, 0750)
+}
+
+func getCacheDir() string {
+	configDir := getConfigDir()
+	return path.Join(configDir, "cache")
+}
+
+func getCacheSnapshotPath() string {
+	return path.Join(getCacheDir(), "snapshot.gob")
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/groupcache"
+	"github.com/varnamproject/govarnam/govarnamgo"
+)
+
+const (
+	// Path the peers fetch results from each other at.
+	clusterBasePath = "/_groupcache/"
+
+	defaultClusterCacheSize = 64 // 64 MB per group
+	defaultClusterTimeout   = 5 * time.Second
+
+	// Header the peers send the shared secret in.
+	clusterSecretHeader = "X-Varnamd-Cluster-Secret"
+)
+
+var (
+	// Time a peer has to answer, see getFromCluster.
+	clusterTimeout = defaultClusterTimeout
+
+	// Expiry of the cache, which the sharded results follow.
+	clusterTTL map[string]time.Duration
+)
+
+// clusterConfig is the [cluster] section of the config. Results of /tl and
+// /atl are sharded across the peers by key, and each key is computed only
+// by the peer owning it. The local cache stays in front as the hot tier.
+//
+// The peers share only the generations of the schemes, through a redis server,
+// so that invalidations reach the local caches of the peers and the sharded results.
+type clusterConfig struct {
+	// URL the peers reach this instance at, as it appears in peers.
+	Self  string   `koanf:"self"`
+	Peers []string `koanf:"peers"`
+
+	// Address the peers are served at, apart from the public API
+	// so that it can be kept to the network of the peers.
+	Address string `koanf:"address"`
+
+	// Shared by the peers. Requests from peers without it are refused.
+	Secret string `koanf:"secret"`
+
+	// Time a peer has to answer before the result is computed locally.
+	Timeout time.Duration `koanf:"timeout"`
+
+	// Size of the results held by each group in MB.
+	Size int `koanf:"size"`
+
+	// Server the generations of the schemes are shared through. Not
+	// needed with the redis cache, which shares them already.
+	RedisAddress  string `koanf:"redis-address"`
+	RedisPassword string `koanf:"redis-password"`
+	RedisDB       int    `koanf:"redis-db"`
+}
+
+// initCluster joins the peers and creates the groups of the namespaces
+// which are sharded. It returns the handler the peers are served by.
+func initCluster(cfg clusterConfig, cache Cache) (http.Handler, error) {
+	if cfg.Self == "" || cfg.Address == "" {
+		return nil, errors.New("cluster.self and cluster.address are required for a cluster")
+	}
+
+	switch c := cache.(type) {
+	case *freeCache:
+		if cfg.RedisAddress == "" {
+			return nil, errors.New("cluster.redis-address is required for a cluster with the freecache backend")
+		}
+
+		c.shared = newRedisGenerations(newRedisPool(cfg.RedisAddress, cfg.RedisPassword, cfg.RedisDB))
+		clusterTTL = c.ttl
+
+	case *redisCache:
+		clusterTTL = c.ttl
+
+	default:
+		return nil, errors.New("a cluster requires the cache to be enabled")
+	}
+
+	if cfg.Timeout > 0 {
+		clusterTimeout = cfg.Timeout
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: clusterTimeout}).DialContext,
+		ResponseHeaderTimeout: clusterTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   64,
+	}
+
+	if cfg.Secret != "" {
+		transport = clusterSecretTransport{secret: cfg.Secret, next: transport}
+	}
+
+	pool := groupcache.NewHTTPPoolOpts(cfg.Self, &groupcache.HTTPPoolOptions{BasePath: clusterBasePath})
+
+	// The peers are given the transport when they're set
+	pool.Transport = func(context.Context) http.RoundTripper { return transport }
+	pool.Set(cfg.Peers...)
+
+	size := cfg.Size
+	if size <= 0 {
+		size = defaultClusterCacheSize
+	}
+
+	cacheGroups["tl"] = groupcache.NewGroup("tl", int64(size)<<20, groupcache.GetterFunc(fillTransliteration))
+	cacheGroups["atl"] = groupcache.NewGroup("atl", int64(size)<<20, groupcache.GetterFunc(fillAdvancedTransliteration))
+
+	return requireClusterSecret(cfg.Secret, pool), nil
+}
+
+// serveCluster serves the peers at their own address.
+func serveCluster(app *App, address string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: clusterTimeout,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	app.log.Printf("serving cluster peers on %s", address)
+	app.log.Fatal(srv.ListenAndServe())
+}
+
+// clusterSecretTransport sends the shared secret with the requests to the peers.
+type clusterSecretTransport struct {
+	secret string
+	next   http.RoundTripper
+}
+
+func (t clusterSecretTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(clusterSecretHeader, t.secret)
+
+	return t.next.RoundTrip(req)
+}
+
+// requireClusterSecret refuses the requests without the shared secret, if there's one.
+func requireClusterSecret(secret string, next http.Handler) http.Handler {
+	if secret == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(clusterSecretHeader)), []byte(secret)) != 1 {
+			http.Error(w, "invalid cluster secret", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getFromCluster gets the value of a cache key of a namespace from the peer owning it.
+// It returns false if the namespace isn't sharded or the key can't be cached.
+// The peer has clusterTimeout to answer before the value is computed locally,
+// which gets as long again.
+func getFromCluster(ctx context.Context, namespace string, key string) ([]byte, bool, error) {
+	group, ok := cacheGroups[namespace]
+	if !ok || isUncachedKey(key) {
+		return nil, false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*clusterTimeout)
+	defer cancel()
+
+	var data []byte
+	if err := group.Get(ctx, clusterKey(key, time.Now()), groupcache.AllocatingByteSliceSink(&data)); err != nil {
+		return nil, true, err
+	}
+
+	return data, true, nil
+}
+
+// clusterKey is the key of a cache key in the sharded results. groupcache
+// never expires results, so the key moves on at every expiry of its
+// namespace and the old results are left to be evicted.
+func clusterKey(key string, now time.Time) string {
+	bucket := now.Unix() / int64(cacheExpiry(clusterTTL, key))
+	return strconv.FormatInt(bucket, 10) + ":" + key
+}
+
+// parseCacheKey finds the scheme and the word of a key built by clusterKey from a key
+// built by Cache.Key with a single part. Schemes are matched against the known schemes
+// since identifiers can have a '-'.
+func parseCacheKey(key string) (string, string, error) {
+	i := strings.IndexByte(key, ':')
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid cluster cache key: %s", key)
+	}
+
+	if _, err := strconv.ParseInt(key[:i], 10, 64); err != nil {
+		return "", "", fmt.Errorf("invalid cluster cache key: %s", key)
+	}
+
+	key = key[i+1:]
+	prefix := cacheKeyPrefix(key) + "-"
+
+	for _, sd := range schemeDetails {
+		rest := strings.TrimPrefix(key, prefix+sd.Identifier+"-")
+		if rest == key {
+			continue
+		}
+
+		// Skip the generation. A scheme which is a prefix of another
+		// scheme leaves the rest of the identifier here instead.
+		i := strings.IndexByte(rest, '-')
+		if i < 0 {
+			continue
+		}
+
+		if _, err := strconv.ParseUint(rest[:i], 10, 64); err != nil {
+			continue
+		}
+
+		return sd.Identifier, rest[i+1:], nil
+	}
+
+	return "", "", fmt.Errorf("invalid cluster cache key: %s", key)
+}
+
+// clusterGroupStats are the stats of a sharded namespace on this peer.
+type clusterGroupStats struct {
+	// Gets from this peer and from its peers.
+	Gets      int64 `json:"gets"`
+	CacheHits int64 `json:"cache_hits"`
+
+	// Results fetched from the peers owning them.
+	PeerLoads  int64 `json:"peer_loads"`
+	PeerErrors int64 `json:"peer_errors"`
+
+	// Results computed by this peer.
+	LocalLoads    int64 `json:"local_loads"`
+	LocalLoadErrs int64 `json:"local_load_errors"`
+
+	// Gets from the peers.
+	ServerRequests int64 `json:"server_requests"`
+}
+
+func clusterStats() map[string]clusterGroupStats {
+	stats := make(map[string]clusterGroupStats, len(cacheGroups))
+
+	for ns, g := range cacheGroups {
+		stats[ns] = clusterGroupStats{
+			Gets:           g.Stats.Gets.Get(),
+			CacheHits:      g.Stats.CacheHits.Get(),
+			PeerLoads:      g.Stats.PeerLoads.Get(),
+			PeerErrors:     g.Stats.PeerErrors.Get(),
+			LocalLoads:     g.Stats.LocalLoads.Get(),
+			LocalLoadErrs:  g.Stats.LocalLoadErrs.Get(),
+			ServerRequests: g.Stats.ServerRequests.Get(),
+		}
+	}
+
+	return stats
+}
+
+// fillTransliteration computes a tl- key owned by this peer.
+func fillTransliteration(ctx context.Context, key string, dest groupcache.Sink) error {
+	scheme, word, err := parseCacheKey(key)
+	if err != nil {
+		return err
+	}
+
+	result, err := transliterate(ctx, scheme, word)
+	if err != nil {
+		return err
+	}
+
+	var words []string
+	for _, sug := range result.([]govarnamgo.Suggestion) {
+		words = append(words, sug.Word)
+	}
+
+	return dest.SetString(strings.Join(words, stringSeparator))
+}
+
+// fillAdvancedTransliteration computes an atl- key owned by this peer.
+func fillAdvancedTransliteration(ctx context.Context, key string, dest groupcache.Sink) error {
+	scheme, word, err := parseCacheKey(key)
+	if err != nil {
+		return err
+	}
+
+	result, err := transliterateAdvanced(ctx, scheme, word)
+	if err != nil {
+		return err
+	}
+
+	b, err := encodeCacheItem(toAdvancedTransliterationResponse(result.(govarnamgo.TransliterationResult)))
+	if err != nil {
+		return err
+	}
+
+	return dest.SetBytes(b)
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang/groupcache/consistenthash"
+	"github.com/knadh/stuffbin"
+)
+
+// Set in the processes running a peer, see TestClusterPeer.
+const (
+	testClusterSelfEnv   = "VARNAMD_TEST_CLUSTER_SELF"
+	testClusterPeersEnv  = "VARNAMD_TEST_CLUSTER_PEERS"
+	testClusterRedisEnv  = "VARNAMD_TEST_CLUSTER_REDIS"
+	testClusterSecretEnv = "VARNAMD_TEST_CLUSTER_SECRET"
+
+	testClusterSecret = "peers-only"
+)
+
+// testPeer is a peer of the cluster running in a process of its own.
+type testPeer struct {
+	url     string
+	public  string
+	cluster string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+}
+
+// Results are computed only by the peer owning their key, and are fetched from it by the others.
+// Each peer keeps them in its local cache, which a flush on any peer invalidates.
+// groupcache allows a single pool in a process, so each peer is run in a process of its own.
+func TestCluster(t *testing.T) {
+	if os.Getenv(testClusterSelfEnv) != "" {
+		t.Skip("running a peer")
+	}
+
+	srv := miniredis.RunT(t)
+
+	peers := make([]*testPeer, 3)
+	for i := range peers {
+		peers[i] = startTestPeer(t)
+	}
+
+	var urls []string
+	for _, p := range peers {
+		urls = append(urls, p.url)
+	}
+
+	for _, p := range peers {
+		p.run(t, urls, srv.Addr())
+	}
+
+	for _, p := range peers {
+		p.waitReady(t)
+	}
+
+	var (
+		ring     = consistenthash.New(50, nil)
+		patterns = []string{"mala", "kamala", "nama", "pala", "tala", "vala", "sala", "yala", "dala"}
+		owned    = make(map[string]int64)
+		fetched  = make(map[string]int64)
+	)
+
+	ring.Add(urls...)
+
+	getAll := func(gen uint64) {
+		t.Helper()
+
+		for i, pattern := range patterns {
+			var (
+				p     = peers[i%len(peers)]
+				owner = ring.Get(clusterKey(buildCacheKey("tl", "ml", gen, []string{pattern}), time.Now()))
+				resp  transliterationResponse
+			)
+
+			p.get(t, "/tl/ml/"+pattern, &resp)
+			if len(resp.Result) == 0 {
+				t.Fatalf("no results for %s from %s", pattern, p.url)
+			}
+
+			owned[owner]++
+			if owner != p.url {
+				fetched[p.url]++
+			}
+		}
+	}
+
+	checkStats := func() {
+		t.Helper()
+
+		for _, p := range peers {
+			var resp clusterStatsResponse
+			p.get(t, "/cache/cluster", &resp)
+
+			stats := resp.Groups["tl"]
+			if stats.LocalLoads != owned[p.url] {
+				t.Errorf("%s computed %d results, expected the %d it owns", p.url, stats.LocalLoads, owned[p.url])
+			}
+
+			if stats.PeerLoads != fetched[p.url] || stats.PeerErrors != 0 {
+				t.Errorf("%s fetched %d results with %d errors, expected %d", p.url, stats.PeerLoads, stats.PeerErrors, fetched[p.url])
+			}
+		}
+	}
+
+	getAll(0)
+	checkStats()
+
+	// Served by the local cache of each peer this time
+	for i, pattern := range patterns {
+		var resp transliterationResponse
+		peers[i%len(peers)].get(t, "/tl/ml/"+pattern, &resp)
+	}
+
+	checkStats()
+
+	// A flush on a peer reaches the others once they read the generations again
+	res, err := http.Post("http://"+peers[0].public+"/cache/flush/ml", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 flushing the cache, got %d", res.StatusCode)
+	}
+
+	if got, _ := srv.Get(redisKeyPrefix + "gen:ml"); got != "1" {
+		t.Fatalf("expected the shared generation to be 1, got %q", got)
+	}
+
+	if keys := srv.Keys(); len(keys) != 1 {
+		t.Fatalf("expected only the generations in redis, got %v", keys)
+	}
+
+	time.Sleep(redisGenerationTTL)
+
+	getAll(1)
+	checkStats()
+
+	// Peers are served only at their own address, and only with the secret
+	p := peers[0]
+
+	res, err = http.Get(p.url + clusterBasePath + "tl/0:tl-ml-0-mala")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a request without the secret to be refused, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get("http://" + p.public + clusterBasePath + "tl/0:tl-ml-0-mala")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the cache not to be served at the public address, got %d", res.StatusCode)
+	}
+}
+
+// TestClusterPeer runs a peer of TestCluster. The public API and the peers are
+// served at the listeners passed as the first and the second extra file.
+func TestClusterPeer(t *testing.T) {
+	self := os.Getenv(testClusterSelfEnv)
+	if self == "" {
+		t.Skip("run by TestCluster")
+	}
+
+	maxHandleCounts = map[string]int{"default": 2}
+	handleAcquireTimeout = defaultHandleAcquireTimeout
+	learnQueueSizes = map[string]int{"default": defaultChanSize}
+
+	fs, err := stuffbin.NewFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewCache(cacheConfig{Enabled: true, Backend: cacheBackendFreecache, Size: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{cache: cache, log: log.New(ioutil.Discard, "", 0), fs: fs}
+
+	initHandlePools()
+	app.initChannels()
+
+	handler, err := initCluster(clusterConfig{
+		Self:         self,
+		Peers:        strings.Split(os.Getenv(testClusterPeersEnv), ","),
+		Address:      strings.TrimPrefix(self, "http://"),
+		Secret:       os.Getenv(testClusterSecretEnv),
+		Timeout:      time.Second,
+		RedisAddress: os.Getenv(testClusterRedisEnv),
+	}, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, h := range []http.Handler{initHandlers(app, true), handler} {
+		l, err := net.FileListener(os.NewFile(uintptr(3+i), "listener"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv := &httptest.Server{Listener: l, Config: &http.Server{Handler: h}}
+		srv.Start()
+		defer srv.Close()
+	}
+
+	// Served until TestCluster is done
+	_, _ = io.Copy(ioutil.Discard, os.Stdin)
+}
+
+// startTestPeer opens the listeners of a peer.
+func startTestPeer(t *testing.T) *testPeer {
+	t.Helper()
+
+	p := &testPeer{}
+
+	var files []*os.File
+	for _, addr := range []*string{&p.public, &p.cluster} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The peer has a copy of its own
+		_ = l.Close()
+		t.Cleanup(func() { _ = f.Close() })
+
+		*addr = l.Addr().String()
+		files = append(files, f)
+	}
+
+	p.url = "http://" + p.cluster
+	p.cmd = exec.Command(os.Args[0], "-test.run=^TestClusterPeer$")
+	p.cmd.ExtraFiles = files
+	p.cmd.Stderr = os.Stderr
+
+	return p
+}
+
+// run starts the process of the peer, with learnings of its own.
+func (p *testPeer) run(t *testing.T, peers []string, redisAddr string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "varnamd-peer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	p.cmd.Env = append(os.Environ(),
+		"VARNAM_LEARNINGS_DIR="+dir,
+		"HOME="+dir,
+		testClusterSelfEnv+"="+p.url,
+		testClusterPeersEnv+"="+strings.Join(peers, ","),
+		testClusterRedisEnv+"="+redisAddr,
+		testClusterSecretEnv+"="+testClusterSecret,
+	)
+
+	if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = p.stdin.Close()
+
+		done := make(chan error, 1)
+		go func() { done <- p.cmd.Wait() }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("peer %s failed: %s", p.url, err.Error())
+			}
+		case <-time.After(10 * time.Second):
+			_ = p.cmd.Process.Kill()
+		}
+	})
+}
+
+// waitReady waits until the peer serves its public API.
+func (p *testPeer) waitReady(t *testing.T) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for time.Now().Before(deadline) {
+		res, err := http.Get("http://" + p.public + "/cache/cluster")
+		if err == nil {
+			_ = res.Body.Close()
+
+			if res.StatusCode == http.StatusOK {
+				return
+			}
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("peer %s isn't ready", p.url)
+}
+
+// get reads the JSON response of the public API of the peer into v.
+func (p *testPeer) get(t *testing.T, target string, v interface{}) {
+	t.Helper()
+
+	res, err := http.Get("http://" + p.public + target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(res.Body)
+		t.Fatalf("expected status 200 from %s%s, got %d: %s", p.url, target, res.StatusCode, b)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatalf("error decoding response of %s%s: %s", p.url, target, err.Error())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"unicode"
+
+	"github.com/varnamproject/govarnam/govarnamgo"
+)
+
+// indicScripts are the scripts letters are looked up in, by their Unicode ranges.
+var indicScripts = []string{
+	"Bengali",
+	"Devanagari",
+	"Gujarati",
+	"Gurmukhi",
+	"Kannada",
+	"Malayalam",
+	"Oriya",
+	"Tamil",
+	"Telugu",
+}
+
+var (
+	// Script of each scheme, found from its symbol table. Empty
+	// for schemes which aren't written in one of indicScripts.
+	schemeScripts   = make(map[string]string)
+	schemeScriptsMu sync.Mutex
+)
+
+// schemeCandidate is a scheme which the detected text could be written in.
+type schemeCandidate struct {
+	Identifier  string  `json:"identifier"`
+	LangCode    string  `json:"lang_code"`
+	DisplayName string  `json:"display_name"`
+	Script      string  `json:"script"`
+	Confidence  float64 `json:"confidence"`
+}
+
+// scriptOf returns the script of a letter, if it's one of indicScripts.
+func scriptOf(r rune) (string, bool) {
+	for _, script := range indicScripts {
+		if unicode.Is(unicode.Scripts[script], r) {
+			return script, true
+		}
+	}
+
+	return "", false
+}
+
+// schemeScript returns the script a scheme is written in, which is the
+// script of most of the letters in its symbol table.
+func schemeScript(scheme string) (string, error) {
+	schemeScriptsMu.Lock()
+	script, ok := schemeScripts[scheme]
+	schemeScriptsMu.Unlock()
+
+	if ok {
+		return script, nil
+	}
+
+	symbolsI, err := searchSymbolTable(context.Background(), scheme, govarnamgo.NewSearchSymbol())
+	if err != nil {
+		return "", err
+	}
+
+	counts := make(map[string]int)
+
+	for _, sym := range symbolsI.([]govarnamgo.Symbol) {
+		if !isoLetterSymbolTypes[sym.Type] {
+			continue
+		}
+
+		for _, r := range sym.Value1 + sym.Value2 {
+			if s, ok := scriptOf(r); ok {
+				counts[s]++
+			}
+		}
+	}
+
+	for s, n := range counts {
+		if n > counts[script] || (n == counts[script] && s < script) {
+			script = s
+		}
+	}
+
+	schemeScriptsMu.Lock()
+	schemeScripts[scheme] = script
+	schemeScriptsMu.Unlock()
+
+	return script, nil
+}
+
+// countScripts counts the letters of text in each of indicScripts.
+// The total includes letters of all scripts.
+func countScripts(text string) (map[string]int, int) {
+	var (
+		counts = make(map[string]int)
+		total  int
+	)
+
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsMark(r) {
+			continue
+		}
+
+		total++
+
+		if script, ok := scriptOf(r); ok {
+			counts[script]++
+		}
+	}
+
+	return counts, total
+}
+
+// detectSchemes returns the schemes which can be used for the native text, the most likely first.
+// Confidence is the share of the letters of the text which are in the script of the scheme.
+func detectSchemes(text string) []schemeCandidate {
+	counts, total := countScripts(text)
+	if total == 0 {
+		return []schemeCandidate{}
+	}
+
+	candidates := []schemeCandidate{}
+
+	for _, sd := range schemeDetails {
+		script, err := schemeScript(sd.Identifier)
+		if err != nil || counts[script] == 0 {
+			continue
+		}
+
+		candidates = append(candidates, schemeCandidate{
+			Identifier:  sd.Identifier,
+			LangCode:    sd.LangCode,
+			DisplayName: sd.DisplayName,
+			Script:      script,
+			Confidence:  float64(counts[script]) / float64(total),
+		})
+	}
+
+	// The scheme named after the language is the default one for it
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Confidence != b.Confidence {
+			return a.Confidence > b.Confidence
+		}
+
+		if (a.Identifier == a.LangCode) != (b.Identifier == b.LangCode) {
+			return a.Identifier == a.LangCode
+		}
+
+		return a.Identifier < b.Identifier
+	})
+
+	return candidates
+}
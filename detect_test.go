@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+// Schemes are detected by the script of their symbol table.
+func TestDetectSchemes(t *testing.T) {
+	newTestServer(t)
+
+	if script, err := schemeScript("ml"); err != nil || script != "Malayalam" {
+		t.Fatalf("expected ml to be written in Malayalam, got %q, %v", script, err)
+	}
+
+	candidates := detectSchemes("മലയാളം")
+	if len(candidates) != 1 || candidates[0].Identifier != "ml" || candidates[0].Confidence != 1 {
+		t.Fatalf("expected ml for Malayalam text, got %+v", candidates)
+	}
+
+	if candidates := detectSchemes("नमस्ते"); len(candidates) != 0 {
+		t.Fatalf("expected no scheme for Devanagari text, got %+v", candidates)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/varnamproject/govarnam/govarnamgo"
+	"golang.org/x/net/html"
+)
+
+// Document formats supported by the document transliteration endpoint.
+const (
+	documentMarkdown = "text/markdown"
+	documentHTML     = "text/html"
+	documentSubRip   = "application/x-subrip"
+
+	// Trailer set when a document fails after its output has started.
+	documentErrorTrailer = "X-Transliteration-Error"
+)
+
+var (
+	// Contents of these HTML elements are not human text.
+	htmlSkipElements = map[string]bool{
+		"script":   true,
+		"style":    true,
+		"code":     true,
+		"pre":      true,
+		"kbd":      true,
+		"samp":     true,
+		"textarea": true,
+	}
+
+	subripIndexPattern  = regexp.MustCompile(`^\d+$`)
+	subripTimingPattern = regexp.MustCompile(`^\d+:\d+:\d+[,.]\d+\s*-->\s*\d+:\d+:\d+[,.]\d+`)
+)
+
+// documentTransliterator transliterates the human text in a document.
+// Documents are read as they are uploaded, so a handle is borrowed for
+// every word instead of holding one for a slow upload.
+type documentTransliterator struct {
+	ctx      context.Context
+	app      *App
+	langCode string
+}
+
+// word returns the best transliteration of a word, or the word itself if there's none.
+func (d *documentTransliterator) word(word string) string {
+	sugs, err := d.app.cache.GetString(d.app.cache.Key("tl", d.langCode, word))
+	if err != nil {
+		var result interface{}
+
+		result, err = getOrCreateHandler(d.ctx, d.langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+			return transliterateAndCache(d.ctx, d.app, handle, d.langCode, word)
+		})
+		if err != nil {
+			d.app.log.Printf("error in transliterating, word: %s, err: %s", word, err.Error())
+			return word
+		}
+
+		sugs = result.([]string)
+	}
+
+	if len(sugs) == 0 || sugs[0] == "" {
+		return word
+	}
+
+	return sugs[0]
+}
+
+// text transliterates the Latin words in text and keeps everything else.
+func (d *documentTransliterator) text(text string) string {
+	var b strings.Builder
+
+	for _, t := range tokenizeText(text) {
+		if t.Kind == tokenWord && len(t.Text) <= 300 {
+			b.WriteString(d.word(t.Text))
+		} else {
+			b.WriteString(t.Text)
+		}
+	}
+
+	return b.String()
+}
+
+// textOutside transliterates text except the spans found by skip.
+// skip returns the end of a span starting at i, or -1 if there's none.
+func (d *documentTransliterator) textOutside(text string, skip func(s string, i int) int) string {
+	var (
+		b     strings.Builder
+		start = 0
+	)
+
+	for i := 0; i < len(text); {
+		end := skip(text, i)
+		if end < 0 {
+			i++
+			continue
+		}
+
+		b.WriteString(d.text(text[start:i]))
+		b.WriteString(text[i:end])
+		start, i = end, end
+	}
+
+	b.WriteString(d.text(text[start:]))
+
+	return b.String()
+}
+
+// markdownSpan matches inline code, link destinations and inline HTML.
+func markdownSpan(s string, i int) int {
+	switch {
+	case s[i] == '`':
+		n := 1
+		for i+n < len(s) && s[i+n] == '`' {
+			n++
+		}
+
+		end := strings.Index(s[i+n:], strings.Repeat("`", n))
+		if end < 0 {
+			return -1
+		}
+
+		return i + n + end + n
+
+	case s[i] == ']' && i+1 < len(s) && s[i+1] == '(':
+		end := strings.IndexByte(s[i:], ')')
+		if end < 0 {
+			return -1
+		}
+
+		return i + end + 1
+
+	case s[i] == '<':
+		return tagSpan(s, i)
+	}
+
+	return -1
+}
+
+// tagSpan matches an inline tag like <i> or <font color="red">.
+func tagSpan(s string, i int) int {
+	if s[i] != '<' || i+1 >= len(s) || !(isLatinLetter(rune(s[i+1])) || s[i+1] == '/' || s[i+1] == '!') {
+		return -1
+	}
+
+	end := strings.IndexByte(s[i:], '>')
+	if end < 0 {
+		return -1
+	}
+
+	return i + end + 1
+}
+
+// subripSpan matches inline tags and {\an8} style positioning tags.
+func subripSpan(s string, i int) int {
+	if s[i] == '{' && i+1 < len(s) && s[i+1] == '\\' {
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return -1
+		}
+
+		return i + end + 1
+	}
+
+	return tagSpan(s, i)
+}
+
+// readLines calls f with every line in r including the line ending.
+func readLines(r io.Reader, f func(line string)) error {
+	reader := bufio.NewReader(r)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			f(line)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isBlankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func isIndentedLine(line string) bool {
+	return strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")
+}
+
+// codeFence returns the fence of a fenced code block line, if any.
+func codeFence(line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return ""
+	}
+
+	for _, c := range []string{"```", "~~~"} {
+		if strings.HasPrefix(trimmed, c) {
+			n := 0
+			for n < len(trimmed) && trimmed[n] == c[0] {
+				n++
+			}
+
+			return trimmed[:n]
+		}
+	}
+
+	return ""
+}
+
+// markdown transliterates a Markdown document leaving code blocks,
+// inline code, link destinations and HTML tags untouched.
+func (d *documentTransliterator) markdown(r io.Reader, write func(string)) error {
+	var (
+		fence     string
+		prevBlank = true
+		inIndent  bool
+	)
+
+	return readLines(r, func(line string) {
+		if fence != "" {
+			// Closing fence should be of the same kind, at least as long
+			// and shouldn't be followed by anything else.
+			f := codeFence(line)
+			if f != "" && f[0] == fence[0] && len(f) >= len(fence) && isBlankLine(strings.TrimLeft(strings.TrimSpace(line), f[:1])) {
+				fence = ""
+			}
+
+			write(line)
+			return
+		}
+
+		if f := codeFence(line); f != "" {
+			fence = f
+			write(line)
+			return
+		}
+
+		blank := isBlankLine(line)
+		inIndent = !blank && isIndentedLine(line) && (prevBlank || inIndent)
+		prevBlank = blank
+
+		if inIndent {
+			write(line)
+			return
+		}
+
+		write(d.textOutside(line, markdownSpan))
+	})
+}
+
+// subrip transliterates the subtitle text in a SubRip document leaving
+// cue numbers, timings and formatting tags untouched.
+func (d *documentTransliterator) subrip(r io.Reader, write func(string)) error {
+	expectIndex := true
+
+	return readLines(r, func(line string) {
+		trimmed := strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+
+		switch {
+		case trimmed == "":
+			expectIndex = true
+			write(line)
+		case expectIndex && subripIndexPattern.MatchString(trimmed):
+			write(line)
+		case subripTimingPattern.MatchString(trimmed):
+			expectIndex = false
+			write(line)
+		default:
+			expectIndex = false
+			write(d.textOutside(line, subripSpan))
+		}
+	})
+}
+
+// html transliterates the text nodes of an HTML document. Tags, attributes,
+// comments and the contents of elements like script and code are untouched.
+func (d *documentTransliterator) html(r io.Reader, write func(string)) error {
+	var (
+		z         = html.NewTokenizer(r)
+		skipDepth = 0
+	)
+
+	for {
+		tt := z.Next()
+
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return nil
+			}
+
+			return z.Err()
+
+		case html.TextToken:
+			raw := string(z.Raw())
+			if skipDepth > 0 {
+				write(raw)
+				continue
+			}
+
+			text := html.UnescapeString(raw)
+			if out := d.text(text); out != text {
+				write(html.EscapeString(out))
+			} else {
+				write(raw)
+			}
+
+		case html.StartTagToken, html.EndTagToken:
+			raw := string(z.Raw())
+			name, _ := z.TagName()
+
+			if htmlSkipElements[string(name)] {
+				if tt == html.StartTagToken {
+					skipDepth++
+				} else if skipDepth > 0 {
+					skipDepth--
+				}
+			}
+
+			write(raw)
+
+		default:
+			write(string(z.Raw()))
+		}
+	}
+}
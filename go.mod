@@ -3,10 +3,12 @@ module github.com/varnamproject/varnamd
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.4
 	github.com/coocood/freecache v1.1.1
 	github.com/fsnotify/fsnotify v1.5.1 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da
 	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/gomodule/redigo v1.8.9
 	github.com/knadh/koanf v1.3.0
 	github.com/knadh/stuffbin v1.1.0
 	github.com/labstack/echo/v4 v4.6.1
@@ -17,7 +19,7 @@ require (
 	github.com/spf13/pflag v1.0.5
 	github.com/varnamproject/govarnam v1.8.0
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
-	golang.org/x/net v0.0.0-20211029224645-99673261e6eb // indirect
+	golang.org/x/net v0.0.0-20211029224645-99673261e6eb
 	golang.org/x/sys v0.0.0-20211030160813-b3129d9d1021 // indirect
 	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac // indirect
 	google.golang.org/protobuf v1.27.1 // indirect
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/varnamproject/govarnam/govarnamgo"
+)
+
+const (
+	defaultHandleAcquireTimeout = 5 * time.Second
+
+	// Time to wait for a pooled handle before creating one above the pool size.
+	// Pools with a shorter acquire timeout wait half of it, see overflowDelay.
+	handleOverflowDelay = 800 * time.Millisecond
+
+	// Seconds a client is asked to wait when no handle is available.
+	handleRetryAfter = 1
+)
+
+var errHandlePoolExhausted = errors.New("timed out waiting for a varnam handle")
+
+// handlePoolExhaustedError asks the client to retry a request later when
+// every handle of the scheme stayed in use.
+func handlePoolExhaustedError(c echo.Context) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(handleRetryAfter))
+	return echo.NewHTTPError(http.StatusServiceUnavailable, "all varnam handles are busy, try again later")
+}
+
+// handlePool holds the handles of a scheme. size handles are created
+// upfront. When all of them are in use, more handles are created up to
+// ceiling. Handles are never closed, as govarnam numbers a new handle by
+// the count of open ones and closing one can give the next the same number.
+type handlePool struct {
+	scheme  string
+	idle    chan *govarnamgo.VarnamHandle
+	size    int
+	ceiling int
+	timeout time.Duration
+
+	mu      sync.Mutex
+	total   int // Handles open, idle or in use
+	inUse   int
+	created int
+}
+
+// handlePoolStatus is reported in /status.
+type handlePoolStatus struct {
+	InUse   int `json:"in_use"`
+	Idle    int `json:"idle"`
+	Created int `json:"created"`
+	Size    int `json:"size"`
+	Ceiling int `json:"ceiling"`
+}
+
+func newHandlePool(scheme string, size int, ceiling int, timeout time.Duration) (*handlePool, error) {
+	if ceiling < size {
+		ceiling = size
+	}
+
+	p := &handlePool{
+		scheme:  scheme,
+		idle:    make(chan *govarnamgo.VarnamHandle, ceiling),
+		size:    size,
+		ceiling: ceiling,
+		timeout: timeout,
+	}
+
+	for i := 0; i < size; i++ {
+		handle, err := p.create()
+		if err != nil {
+			return nil, err
+		}
+
+		p.idle <- handle
+	}
+
+	return p, nil
+}
+
+// create opens a new handle if the ceiling isn't reached. It returns nil if it is.
+func (p *handlePool) create() (*govarnamgo.VarnamHandle, error) {
+	p.mu.Lock()
+	if p.total >= p.ceiling {
+		p.mu.Unlock()
+		return nil, nil
+	}
+
+	// Reserved before opening, so that concurrent callers can't exceed the ceiling
+	p.total++
+	p.mu.Unlock()
+
+	handle, err := govarnamgo.InitFromID(p.scheme)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		p.total--
+		return nil, err
+	}
+
+	p.created++
+
+	return handle, nil
+}
+
+// acquire borrows a handle, waiting up to the timeout of the pool or until
+// the context is done. Handles are given back with release.
+func (p *handlePool) acquire(ctx context.Context) (*govarnamgo.VarnamHandle, error) {
+	deadline := time.NewTimer(p.timeout)
+	defer deadline.Stop()
+
+	overflow := time.NewTimer(p.overflowDelay())
+	defer overflow.Stop()
+
+	for {
+		select {
+		case handle := <-p.idle:
+			p.markInUse()
+			return handle, nil
+
+		case <-overflow.C:
+			// Handles given back are still waited for if none can be created
+			handle, err := p.create()
+			if err != nil {
+				log.Printf("error initializing varnam handle for %s, err: %s", p.scheme, err.Error())
+				continue
+			}
+
+			if handle != nil {
+				p.markInUse()
+				return handle, nil
+			}
+
+		case <-deadline.C:
+			return nil, errHandlePoolExhausted
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// overflowDelay is the time to wait for a pooled handle before creating one above
+// the pool size. It's at most half the timeout so that there's time left to open one.
+func (p *handlePool) overflowDelay() time.Duration {
+	if d := p.timeout / 2; d < handleOverflowDelay {
+		return d
+	}
+
+	return handleOverflowDelay
+}
+
+// release gives back a handle. idle holds up to ceiling handles, so it doesn't block.
+func (p *handlePool) release(handle *govarnamgo.VarnamHandle) {
+	p.mu.Lock()
+	p.inUse--
+	p.mu.Unlock()
+
+	p.idle <- handle
+}
+
+func (p *handlePool) markInUse() {
+	p.mu.Lock()
+	p.inUse++
+	p.mu.Unlock()
+}
+
+func (p *handlePool) status() handlePoolStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return handlePoolStatus{
+		InUse:   p.inUse,
+		Idle:    len(p.idle),
+		Created: p.created,
+		Size:    p.size,
+		Ceiling: p.ceiling,
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/varnamproject/govarnam/govarnamgo"
+)
+
+// A handle given back is still waited for when none can be created.
+func TestHandlePoolWaitsAfterCreateFails(t *testing.T) {
+	// Handles of an unknown scheme can't be created
+	p, err := newHandlePool("unknown", 0, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not closed, see handlePool
+	handle, err := govarnamgo.InitFromID("ml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(handleOverflowDelay + 200*time.Millisecond)
+		p.idle <- handle
+	}()
+
+	got, err := p.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("expected the handle given back, got %s", err.Error())
+	}
+
+	if got != handle {
+		t.Fatal("expected the handle given back")
+	}
+}
+
+// Requests waiting too long for a handle are asked to retry later.
+func TestHandlePoolExhausted(t *testing.T) {
+	_, e := newTestServer(t)
+
+	p, err := newHandlePool("ml", 1, 1, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handle, err := p.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer p.release(handle)
+
+	pools := handlePools["ml"]
+	handlePools["ml"] = p
+
+	defer func() { handlePools["ml"] = pools }()
+
+	rec := doRequest(t, e, http.MethodGet, "/tl/ml/tadava", nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if got := rec.Header().Get("Retry-After"); got != strconv.Itoa(handleRetryAfter) {
+		t.Fatalf("expected Retry-After %d, got %q", handleRetryAfter, got)
+	}
+}
+
+// Waiting for a handle stops once the context of the request is done.
+func TestHandlePoolAcquireCancelled(t *testing.T) {
+	p, err := newHandlePool("ml", 1, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handle, err := p.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer p.release(handle)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+
+	if _, err := p.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the wait to be cancelled, got %v", err)
+	}
+
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("expected to stop waiting once cancelled, waited %s", waited)
+	}
+}
+
+// A handle above the pool size is opened even when the acquire timeout is shorter than handleOverflowDelay.
+func TestHandlePoolOverflowShortTimeout(t *testing.T) {
+	timeout := handleOverflowDelay / 2
+
+	p, err := newHandlePool("ml", 1, 2, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := p.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer p.release(first)
+
+	second, err := p.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("expected a handle above the pool size within %s, got %s", timeout, err.Error())
+	}
+
+	p.release(second)
+
+	if status := p.status(); status.Created != 2 || status.Idle != 1 {
+		t.Fatalf("expected a handle to be opened above the pool size, got %+v", status)
+	}
+}
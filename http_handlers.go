@@ -4,21 +4,45 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/labstack/echo/v4"
 	"github.com/varnamproject/govarnam/govarnamgo"
 )
 
+const (
+	// maxBatchSize is the maximum number of inputs accepted by a single
+	// batch transliteration request.
+	maxBatchSize = 100
+
+	// maxTextLength is the maximum size in bytes of a text transliteration input.
+	maxTextLength = 10000
+
+	defaultCompletionLimit = 10
+	maxCompletionLimit     = 100
+	maxCompletionOffset    = 1000
+
+	defaultPredictionLimit = 10
+	maxPredictionLimit     = 100
+
+	// maxDocumentSize is the maximum size in bytes of a document to transliterate.
+	maxDocumentSize = 5 << 20
+)
+
 var errCacheSkipped = errors.New("cache skipped")
 
 // Context which gets passed into the groupcache fill function
@@ -44,6 +68,23 @@ type transliterationResponse struct {
 	Input  string   `json:"input"`
 }
 
+// weightedTransliterationResponse is the /tl response when ?include_weights=true.
+type weightedTransliterationResponse struct {
+	standardResponse
+	Result []suggestionResponse `json:"result"`
+	Input  string               `json:"input"`
+}
+
+type batchTransliterationResult struct {
+	Result []string `json:"result"`
+	Error  string   `json:"error,omitempty"`
+}
+
+type batchTransliterationResponse struct {
+	standardResponse
+	Result map[string]batchTransliterationResult `json:"result"`
+}
+
 type suggestionResponse struct {
 	Word      string `json:"word"`
 	Weight    int    `json:"weight"`
@@ -61,6 +102,99 @@ type advancedTransliterationResponse struct {
 	GreedyTokenized              []suggestionResponse `json:"greedy_tokenized"`
 }
 
+// advancedCategory is a single category of suggestions in an advancedTransliterationResponse.
+type advancedCategory struct {
+	Name        string
+	Suggestions []suggestionResponse
+}
+
+func toSuggestionResponses(sugs []govarnamgo.Suggestion) []suggestionResponse {
+	var result []suggestionResponse
+	for _, sug := range sugs {
+		result = append(result, suggestionResponse(sug))
+	}
+
+	return result
+}
+
+func toAdvancedTransliterationResponse(result govarnamgo.TransliterationResult) advancedTransliterationResponse {
+	return advancedTransliterationResponse{
+		ExactWords:                   toSuggestionResponses(result.ExactWords),
+		ExactMatches:                 toSuggestionResponses(result.ExactMatches),
+		DictionarySuggestions:        toSuggestionResponses(result.DictionarySuggestions),
+		PatternDictionarySuggestions: toSuggestionResponses(result.PatternDictionarySuggestions),
+		TokenizerSuggestions:         toSuggestionResponses(result.TokenizerSuggestions),
+		GreedyTokenized:              toSuggestionResponses(result.GreedyTokenized),
+	}
+}
+
+// fillEmpty replaces nil categories with empty ones so that they aren't returned as null.
+func (r *advancedTransliterationResponse) fillEmpty() {
+	if r.ExactWords == nil {
+		r.ExactWords = []suggestionResponse{}
+	}
+	if r.ExactMatches == nil {
+		r.ExactMatches = []suggestionResponse{}
+	}
+	if r.DictionarySuggestions == nil {
+		r.DictionarySuggestions = []suggestionResponse{}
+	}
+	if r.PatternDictionarySuggestions == nil {
+		r.PatternDictionarySuggestions = []suggestionResponse{}
+	}
+	if r.TokenizerSuggestions == nil {
+		r.TokenizerSuggestions = []suggestionResponse{}
+	}
+	if r.GreedyTokenized == nil {
+		r.GreedyTokenized = []suggestionResponse{}
+	}
+}
+
+// category returns the suggestions of the named category.
+func (r *advancedTransliterationResponse) category(name string) *[]suggestionResponse {
+	switch name {
+	case "exact_words":
+		return &r.ExactWords
+	case "exact_matches":
+		return &r.ExactMatches
+	case "dictionary_suggestions":
+		return &r.DictionarySuggestions
+	case "pattern_dictionary_suggestions":
+		return &r.PatternDictionarySuggestions
+	case "tokenizer_suggestions":
+		return &r.TokenizerSuggestions
+	case "greedy_tokenized":
+		return &r.GreedyTokenized
+	}
+
+	return nil
+}
+
+// categories returns the suggestion categories in the order of advancedCategoryNames.
+func (r *advancedTransliterationResponse) categories() []advancedCategory {
+	cats := make([]advancedCategory, len(advancedCategoryNames))
+	for i, name := range advancedCategoryNames {
+		cats[i] = advancedCategory{name, *r.category(name)}
+	}
+
+	return cats
+}
+
+type completionResponse struct {
+	standardResponse
+	Input   string               `json:"input"`
+	Result  []suggestionResponse `json:"result"`
+	Offset  int                  `json:"offset"`
+	Limit   int                  `json:"limit"`
+	HasMore bool                 `json:"has_more"`
+}
+
+type predictionResponse struct {
+	standardResponse
+	After  string               `json:"after"`
+	Result []suggestionResponse `json:"result"`
+}
+
 type metaResponse struct {
 	// Result *libvarnam.CorpusDetails `json:"result"`
 	standardResponse
@@ -72,12 +206,127 @@ type downloadResponse struct {
 	standardResponse
 }
 
+type textTransliterationResponse struct {
+	standardResponse
+	Input  string      `json:"input"`
+	Result string      `json:"result"`
+	Tokens []textToken `json:"tokens"`
+}
+
+// textArgs is the body of a text transliteration request.
+type textArgs struct {
+	Text string `json:"text"`
+}
+
+// reverseTextArgs is the body of a text reverse transliteration request.
+type reverseTextArgs struct {
+	Text  string `json:"text"`
+	Style string `json:"style"`
+}
+
+type reverseTextResponse struct {
+	textTransliterationResponse
+	Style string `json:"style"`
+}
+
+type detectResponse struct {
+	standardResponse
+	Result []schemeCandidate `json:"result"`
+}
+
+type autoReverseTransliterationResponse struct {
+	transliterationResponse
+	Scheme string `json:"scheme"`
+}
+
+type spellcheckResponse struct {
+	standardResponse
+	Input  string            `json:"input"`
+	Result []spellcheckIssue `json:"result"`
+}
+
+// jobResponse is returned by the asynchronous learn and train endpoints.
+type jobResponse struct {
+	standardResponse
+	JobID string `json:"job_id"`
+}
+
+type jobStatusResponse struct {
+	standardResponse
+	Job *learnJob `json:"job"`
+}
+
+type cacheWarmArgs struct {
+	Patterns []string `json:"patterns"`
+}
+
+type cacheWarmResponse struct {
+	standardResponse
+	Warmed int `json:"warmed"`
+	Failed int `json:"failed"`
+}
+
+type cacheStatsResponse struct {
+	standardResponse
+	cacheStats
+}
+
+type clusterStatsResponse struct {
+	standardResponse
+	Groups map[string]clusterGroupStats `json:"groups"`
+}
+
+// cacheFlushKeyArgs identifies the cached result of a word.
+type cacheFlushKeyArgs struct {
+	Namespace string `json:"namespace"`
+	Word      string `json:"word"`
+}
+
+type cacheFlushResponse struct {
+	standardResponse
+	Flushed bool `json:"flushed"`
+}
+
+// deleteResponse is the number of entries removed from the learnings.
+type deleteResponse struct {
+	standardResponse
+	Removed int `json:"removed"`
+}
+
+// deleteBulkArgs read the incoming data for bulk deletion.
+type deleteBulkArgs struct {
+	Words []string `json:"words"`
+}
+
+// queueStatus is the number of requests waiting to be learned and trained for a scheme.
+type queueStatus struct {
+	Learn    int `json:"learn"`
+	Train    int `json:"train"`
+	Capacity int `json:"capacity"`
+}
+
 // Args to read.
 type args struct {
 	LangCode string `json:"lang"`
 	Text     string `json:"text"`
 }
 
+// learnArgs is the text to learn. Weight and LearnedOn (a unix timestamp) are
+// optional and used for words which are not learned yet.
+type learnArgs struct {
+	LangCode  string `json:"lang"`
+	Text      string `json:"text"`
+	Weight    int    `json:"weight"`
+	LearnedOn int64  `json:"learned_on"`
+}
+
+// learnBulkArgs read the incoming data for bulk learning, like frequency lists.
+type learnBulkArgs struct {
+	Text      string `json:"text"`
+	Weight    int    `json:"weight"`
+	LearnedOn int64  `json:"learned_on"`
+}
+
 //TrainArgs read the incoming data
 type trainArgs struct {
 	Pattern string `json:"pattern"`
@@ -100,13 +349,27 @@ type packDownloadArgs struct {
 func handleStatus(c echo.Context) error {
 	uptime := time.Since(startedAt)
 
+	queues := make(map[string]queueStatus)
+	for scheme, q := range learnQueues {
+		queues[scheme] = queueStatus{Learn: q.len(), Train: trainQueues[scheme].len(), Capacity: q.capacity}
+	}
+
+	handles := make(map[string]handlePoolStatus)
+	for scheme, p := range handlePools {
+		handles[scheme] = p.status()
+	}
+
 	resp := struct {
-		Version string `json:"version"`
-		Uptime  string `json:"uptime"`
+		Version string                      `json:"version"`
+		Uptime  string                      `json:"uptime"`
+		Queues  map[string]queueStatus      `json:"queues"`
+		Handles map[string]handlePoolStatus `json:"handles"`
 		standardResponse
 	}{
 		buildVersion + "-" + buildDate,
 		uptime.String(),
+		queues,
+		handles,
 		newStandardResponse(),
 	}
 
@@ -133,24 +396,589 @@ func handleTransliteration(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: too long input"))
 	}
 
-	cacheKey := fmt.Sprintf("tl-%s-%s", langCode, word)
+	opts, err := parseTransliterationOptions(c, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: %s", err.Error()))
+	}
+
+	if opts.Custom {
+		sugs, err := transliterateWithOptions(c.Request().Context(), app, langCode, word, opts)
+		if errors.Is(err, errHandlePoolExhausted) {
+			return handlePoolExhaustedError(c)
+		}
+
+		if err != nil {
+			app.log.Printf("error in transliterating, err: %s", err.Error())
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: %s", err.Error()))
+		}
+
+		if opts.IncludeWeights {
+			return c.JSON(http.StatusOK, weightedTransliterationResponse{standardResponse: newStandardResponse(), Result: sugs, Input: word})
+		}
+
+		return c.JSON(http.StatusOK, transliterationResponse{standardResponse: newStandardResponse(), Result: suggestionWords(sugs), Input: word})
+	}
+
+	cacheKey := app.cache.Key("tl", langCode, word)
 
 	words, err := app.cache.GetString(cacheKey)
 	if err != nil {
-		result, err := transliterate(c.Request().Context(), langCode, word)
+		// Requests for the same word wait for the one transliterating it.
+		// The request context isn't used so that a client going away
+		// doesn't fail the others.
+		result, err := coalesce(cacheKey, func() (interface{}, error) {
+			var words []string
+
+			// In a cluster, the peer owning the key computes it
+			data, ok, err := getFromCluster(context.Background(), "tl", cacheKey)
+			if ok {
+				if err != nil {
+					return nil, err
+				}
+
+				if len(data) > 0 {
+					words = strings.Split(string(data), stringSeparator)
+				}
+
+				_ = app.cache.SetString(cacheKey, words...)
+
+				return words, nil
+			}
+
+			result, err := transliterate(context.Background(), langCode, word)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, sug := range result.([]govarnamgo.Suggestion) {
+				words = append(words, sug.Word)
+			}
+
+			_ = app.cache.SetString(cacheKey, words...)
+
+			return words, nil
+		})
+		if errors.Is(err, errHandlePoolExhausted) {
+			return handlePoolExhaustedError(c)
+		}
+
 		if err != nil {
 			app.log.Printf("error in transliterating, err: %s", err.Error())
 			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: %s", err.Error()))
 		}
 
-		for _, sug := range result.([]govarnamgo.Suggestion) {
-			words = append(words, sug.Word)
+		words = result.([]string)
+	}
+
+	return c.JSON(http.StatusOK, transliterationResponse{standardResponse: newStandardResponse(), Result: words, Input: word})
+}
+
+// handleBatchTransliteration transliterates a JSON array of inputs in one request.
+// Cached inputs are served from the same tl- namespace as handleTransliteration
+// and the rest are transliterated with a single borrowed handle.
+// Errors are reported per input instead of failing the whole request.
+func handleBatchTransliteration(c echo.Context) error {
+	var (
+		langCode = c.Param("langCode")
+		inputs   []string
+		app      = c.Get("app").(*App)
+	)
+
+	if err := c.Bind(&inputs); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error reading request. message: %s", err.Error()))
+	}
+
+	if len(inputs) > maxBatchSize {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given strings. message: more than %d inputs", maxBatchSize))
+	}
+
+	var (
+		results = make(map[string]batchTransliterationResult, len(inputs))
+		words   []string
+	)
+
+	for _, word := range inputs {
+		if len(word) > 300 {
+			results[word] = batchTransliterationResult{Result: []string{}, Error: "too long input"}
+			continue
 		}
 
-		_ = app.cache.SetString(cacheKey, words...)
+		words = append(words, word)
 	}
 
-	return c.JSON(http.StatusOK, transliterationResponse{standardResponse: newStandardResponse(), Result: words, Input: word})
+	suggestions, errs, err := transliterateWords(c.Request().Context(), app, langCode, words)
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error in transliterating, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given strings. message: %s", err.Error()))
+	}
+
+	for word, sugs := range suggestions {
+		results[word] = batchTransliterationResult{Result: sugs}
+	}
+	for word, err := range errs {
+		results[word] = batchTransliterationResult{Result: []string{}, Error: err.Error()}
+	}
+
+	return c.JSON(http.StatusOK, batchTransliterationResponse{standardResponse: newStandardResponse(), Result: results})
+}
+
+// transliterateWords transliterates every word using the tl- cache where possible.
+// Cache misses are transliterated with one borrowed handle and errors are
+// collected per word. The returned error is set only if no handle could be used.
+func transliterateWords(ctx context.Context, app *App, langCode string, words []string) (map[string][]string, map[string]error, error) {
+	var (
+		results = make(map[string][]string, len(words))
+		errs    = make(map[string]error)
+		pending []string
+	)
+
+	for _, word := range words {
+		if _, ok := results[word]; ok {
+			continue
+		}
+
+		sugs, err := app.cache.GetString(app.cache.Key("tl", langCode, word))
+		if err != nil {
+			// Mark as seen so that duplicates aren't queued twice.
+			results[word] = nil
+			pending = append(pending, word)
+			continue
+		}
+
+		results[word] = sugs
+	}
+
+	if len(pending) == 0 {
+		return results, errs, nil
+	}
+
+	_, err := getOrCreateHandler(ctx, langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+		for _, word := range pending {
+			sugs, terr := transliterateAndCache(ctx, app, handle, langCode, word)
+			if terr != nil {
+				app.log.Printf("error in transliterating, word: %s, err: %s", word, terr.Error())
+				delete(results, word)
+				errs[word] = terr
+				continue
+			}
+
+			results[word] = sugs
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return results, errs, nil
+}
+
+// transliterateAndCache transliterates a word with the given handle and
+// caches the result in the tl- namespace.
+func transliterateAndCache(ctx context.Context, app *App, handle *govarnamgo.VarnamHandle, langCode string, word string) ([]string, error) {
+	result, err := handle.Transliterate(ctx, word)
+	if err != nil {
+		return nil, err
+	}
+
+	sugs := []string{}
+	for _, sug := range result {
+		sugs = append(sugs, sug.Word)
+	}
+
+	_ = app.cache.SetString(app.cache.Key("tl", langCode, word), sugs...)
+
+	return sugs, nil
+}
+
+// handleTextTransliteration transliterates a sentence or a paragraph.
+// Only the Latin words in the text are transliterated, everything else
+// (whitespace, punctuation, numbers, URLs, emails and native script)
+// is kept as is.
+func handleTextTransliteration(c echo.Context) error {
+	var (
+		langCode = c.Param("langCode")
+		a        textArgs
+		app      = c.Get("app").(*App)
+	)
+
+	if err := c.Bind(&a); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error reading request. message: %s", err.Error()))
+	}
+
+	if len(a.Text) > maxTextLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given text. message: too long input"))
+	}
+
+	tokens := tokenizeText(a.Text)
+
+	var words []string
+	for _, t := range tokens {
+		if t.Kind == tokenWord && len(t.Text) <= 300 {
+			words = append(words, t.Text)
+		}
+	}
+
+	// Errors of words are logged by transliterateWords and reported in their tokens
+	suggestions, errs, err := transliterateWords(c.Request().Context(), app, langCode, words)
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error in transliterating, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given text. message: %s", err.Error()))
+	}
+
+	var (
+		result strings.Builder
+		offset int
+	)
+
+	for i, t := range tokens {
+		out := t.Text
+
+		if t.Kind == tokenWord {
+			tokens[i].Result = suggestions[t.Text]
+			if len(tokens[i].Result) > 0 {
+				out = tokens[i].Result[0]
+			}
+
+			if err, ok := errs[t.Text]; ok {
+				tokens[i].Error = err.Error()
+			}
+		}
+
+		if tokens[i].Result == nil {
+			tokens[i].Result = []string{}
+		}
+
+		tokens[i].OutputStart = offset
+		offset += utf8.RuneCountInString(out)
+		tokens[i].OutputEnd = offset
+
+		result.WriteString(out)
+	}
+
+	return c.JSON(http.StatusOK, textTransliterationResponse{
+		standardResponse: newStandardResponse(),
+		Input:            a.Text,
+		Result:           result.String(),
+		Tokens:           tokens,
+	})
+}
+
+// handleSpellcheck flags the native words in a text which are not in the
+// learned dictionary along with corrections for them.
+func handleSpellcheck(c echo.Context) error {
+	var (
+		langCode = c.Param("langCode")
+		a        textArgs
+		app      = c.Get("app").(*App)
+	)
+
+	if err := c.Bind(&a); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error reading request. message: %s", err.Error()))
+	}
+
+	if len(a.Text) > maxTextLength {
+		return echo.NewHTTPError(http.StatusBadRequest, "error checking spelling. message: too long input")
+	}
+
+	var (
+		ctx    = c.Request().Context()
+		issues = []spellcheckIssue{}
+	)
+
+	_, err := getOrCreateHandler(ctx, langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+		// Same word is checked only once
+		checked := make(map[string][]suggestionResponse)
+
+		for _, t := range tokenizeText(a.Text) {
+			if t.Kind != tokenNative || len(t.Text) > 300 {
+				continue
+			}
+
+			corrections, ok := checked[t.Text]
+			if !ok {
+				known, sugs, err := checkSpelling(ctx, handle, t.Text)
+				if err != nil {
+					app.log.Printf("error checking spelling, word: %s, err: %s", t.Text, err.Error())
+					continue
+				}
+
+				if !known {
+					corrections = sugs
+					if corrections == nil {
+						corrections = []suggestionResponse{}
+					}
+				}
+
+				checked[t.Text] = corrections
+			}
+
+			if corrections != nil {
+				issues = append(issues, spellcheckIssue{Word: t.Text, Start: t.Start, End: t.End, Suggestions: corrections})
+			}
+		}
+
+		return nil, nil
+	})
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error checking spelling, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error checking spelling. message: %s", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, spellcheckResponse{standardResponse: newStandardResponse(), Input: a.Text, Result: issues})
+}
+
+// handleDetect returns the schemes the given native text could be written in.
+func handleDetect(c echo.Context) error {
+	var (
+		a   textArgs
+		app = c.Get("app").(*App)
+	)
+
+	if err := c.Bind(&a); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error reading request. message: %s", err.Error()))
+	}
+
+	if len(a.Text) > maxTextLength {
+		return echo.NewHTTPError(http.StatusBadRequest, "error detecting scheme. message: too long input")
+	}
+
+	return c.JSON(http.StatusOK, detectResponse{standardResponse: newStandardResponse(), Result: detectSchemes(a.Text)})
+}
+
+// handleAutoReverseTransliteration is handleReverseTransliteration
+// with the scheme detected from the word.
+func handleAutoReverseTransliteration(c echo.Context) error {
+	var (
+		word = c.Param("word")
+		app  = c.Get("app").(*App)
+	)
+
+	// Resolving a bug in echo
+	// https://github.com/labstack/echo/issues/561
+	var err error
+	word, err = url.QueryUnescape(word)
+	if err != nil {
+		app.log.Printf("error in reverse transliterationg, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: %s", err.Error()))
+	}
+
+	if len(word) > 300 {
+		return echo.NewHTTPError(http.StatusBadRequest, "error transliterating given string. message: too long input")
+	}
+
+	candidates := detectSchemes(word)
+	if len(candidates) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unable to detect language of word: %s", word))
+	}
+
+	langCode := candidates[0].Identifier
+
+	results, err := reverseTransliterateWords(c.Request().Context(), app, langCode, []string{word})
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error in reverse transliterationg, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: %s", err.Error()))
+	}
+
+	words := results[word]
+	if len(words) <= 0 {
+		app.log.Printf("no reverse transliteration found for lang: %s word: %s", langCode, word)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("no transliteration found for lanugage: %s, word: %s", langCode, word))
+	}
+
+	return c.JSON(http.StatusOK, autoReverseTransliterationResponse{
+		transliterationResponse: transliterationResponse{standardResponse: newStandardResponse(), Result: words, Input: word},
+		Scheme:                  langCode,
+	})
+}
+
+// handleReverseTextTransliteration romanizes the native words in a text.
+// Latin text and everything else which isn't native script is kept as is.
+// Style is one of scheme (default), iso or casual. iso is supported
+// only by schemes whose letters are all known to the ISO 15919 tables.
+func handleReverseTextTransliteration(c echo.Context) error {
+	var (
+		langCode = c.Param("langCode")
+		a        reverseTextArgs
+		app      = c.Get("app").(*App)
+	)
+
+	if err := c.Bind(&a); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error reading request. message: %s", err.Error()))
+	}
+
+	if a.Style == "" {
+		a.Style = styleScheme
+	}
+
+	if !isValidRomanizationStyle(a.Style) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid style: %s", a.Style))
+	}
+
+	if len(a.Text) > maxTextLength {
+		return echo.NewHTTPError(http.StatusBadRequest, "error transliterating given text. message: too long input")
+	}
+
+	if _, ok := handlePools[langCode]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid scheme identifier")
+	}
+
+	tokens := tokenizeText(a.Text)
+
+	var words []string
+	for _, t := range tokens {
+		if t.Kind == tokenNative && len(t.Text) <= 300 {
+			words = append(words, t.Text)
+		}
+	}
+
+	romanized, err := romanizeWords(c.Request().Context(), app, langCode, words, a.Style)
+	if err == errStyleNotSupported {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("style %s isn't supported by scheme %s", a.Style, langCode))
+	}
+
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error in reverse transliterating, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given text. message: %s", err.Error()))
+	}
+
+	var (
+		result strings.Builder
+		offset int
+	)
+
+	for i, t := range tokens {
+		out := t.Text
+
+		if t.Kind == tokenNative {
+			tokens[i].Result = romanized[t.Text]
+			if len(tokens[i].Result) > 0 {
+				out = tokens[i].Result[0]
+			}
+		}
+
+		if tokens[i].Result == nil {
+			tokens[i].Result = []string{}
+		}
+
+		tokens[i].OutputStart = offset
+		offset += utf8.RuneCountInString(out)
+		tokens[i].OutputEnd = offset
+
+		result.WriteString(out)
+	}
+
+	return c.JSON(http.StatusOK, reverseTextResponse{
+		textTransliterationResponse: textTransliterationResponse{
+			standardResponse: newStandardResponse(),
+			Input:            a.Text,
+			Result:           result.String(),
+			Tokens:           tokens,
+		},
+		Style: a.Style,
+	})
+}
+
+// handleDocumentTransliteration transliterates a Markdown, HTML or SubRip document
+// given in the request body and streams back the document in the same format.
+// The format is picked from the Content-Type of the request.
+func handleDocumentTransliteration(c echo.Context) error {
+	var (
+		langCode = c.Param("langCode")
+		app      = c.Get("app").(*App)
+	)
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(c.Request().Header.Get(echo.HeaderContentType), ";")[0]))
+
+	var transform func(d *documentTransliterator, r io.Reader, write func(string)) error
+
+	switch mediaType {
+	case documentMarkdown, "text/x-markdown":
+		transform = (*documentTransliterator).markdown
+	case documentHTML:
+		transform = (*documentTransliterator).html
+	case documentSubRip, "text/srt":
+		transform = (*documentTransliterator).subrip
+	default:
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported document type: %s", mediaType))
+	}
+
+	if _, ok := handlePools[langCode]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid scheme identifier")
+	}
+
+	if c.Request().ContentLength > maxDocumentSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("error transliterating document. message: document is larger than %d bytes", maxDocumentSize))
+	}
+
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxDocumentSize)
+
+	// Errors after the output has started, like a body cut off by the
+	// size limit, are sent in this trailer.
+	c.Response().Header().Set(echo.HeaderContentType, mediaType+"; charset=utf-8")
+	c.Response().Header().Set("Trailer", documentErrorTrailer)
+
+	sendOutput := func(msg string) {
+		if !c.Response().Committed {
+			c.Response().WriteHeader(http.StatusOK)
+		}
+
+		_, _ = c.Response().Write([]byte(msg))
+		c.Response().Flush()
+	}
+
+	d := &documentTransliterator{ctx: c.Request().Context(), app: app, langCode: langCode}
+
+	if err := transform(d, body, sendOutput); err != nil {
+		app.log.Printf("error in transliterating document, err: %s", err.Error())
+
+		if !c.Response().Committed {
+			c.Response().Header().Del("Trailer")
+
+			if errors.Is(err, errHandlePoolExhausted) {
+				return handlePoolExhaustedError(c)
+			}
+
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating document. message: %s", err.Error()))
+		}
+
+		c.Response().Header().Set(documentErrorTrailer, err.Error())
+
+		return nil
+	}
+
+	// Empty documents
+	if !c.Response().Committed {
+		c.Response().WriteHeader(http.StatusOK)
+	}
+
+	return nil
 }
 
 func handleAdvancedTransliteration(c echo.Context) error {
@@ -173,68 +1001,322 @@ func handleAdvancedTransliteration(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: too long input"))
 	}
 
+	opts, err := parseTransliterationOptions(c, true)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: %s", err.Error()))
+	}
+
 	var response advancedTransliterationResponse
-	var cacheKey = fmt.Sprintf("atl-%s-%s", langCode, word)
+	var cacheKey = app.cache.Key("atl", langCode, word)
+
+	// Different option sets are cached separately
+	if opts.Custom {
+		cacheKey = app.cache.Key("atl", langCode, opts.cacheKey(), word)
+	}
 
 	cached, err := app.cache.Get(cacheKey)
 	if err == nil {
 		response = cached.(advancedTransliterationResponse)
 	} else {
-		result, err := transliterateAdvanced(c.Request().Context(), langCode, word)
+		// Coalesced like handleTransliteration
+		result, err := coalesce(cacheKey, func() (interface{}, error) {
+			// Results filtered by options aren't sharded
+			if !opts.Custom {
+				data, ok, err := getFromCluster(context.Background(), "atl", cacheKey)
+				if ok {
+					if err != nil {
+						return nil, err
+					}
+
+					cached, err := decodeCacheItem(data)
+					if err != nil {
+						return nil, err
+					}
+
+					_ = app.cache.Set(cacheKey, cached)
+
+					return cached, nil
+				}
+			}
+
+			result, err := transliterateAdvanced(context.Background(), langCode, word)
+			if err != nil {
+				return nil, err
+			}
+
+			response := toAdvancedTransliterationResponse(result.(govarnamgo.TransliterationResult))
+
+			if opts.Custom {
+				response = opts.filterAdvanced(response)
+			}
+
+			_ = app.cache.Set(cacheKey, response)
+
+			return response, nil
+		})
+		if errors.Is(err, errHandlePoolExhausted) {
+			return handlePoolExhaustedError(c)
+		}
+
 		if err != nil {
 			app.log.Printf("error in transliterating, err: %s", err.Error())
 			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: %s", err.Error()))
 		}
 
-		var varnamResult = result.(govarnamgo.TransliterationResult)
+		response = result.(advancedTransliterationResponse)
+	}
+
+	response.Input = word
+
+	if opts.Custom {
+		response.standardResponse = newStandardResponse()
+		return c.JSON(http.StatusOK, opts.serializeAdvanced(response))
+	}
+
+	// Don't return null for array responses
+	response.fillEmpty()
+
+	response.standardResponse = newStandardResponse()
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// handleCompletion returns learned words starting with a native script prefix.
+// Results are paginated with ?offset= and ?limit=.
+func handleCompletion(c echo.Context) error {
+	var (
+		langCode = c.Param("langCode")
+		prefix   = c.Param("prefix")
+		app      = c.Get("app").(*App)
+		offset   = 0
+		limit    = defaultCompletionLimit
+	)
+
+	// Resolving a bug in echo
+	// https://github.com/labstack/echo/issues/561
+	var err error
+	prefix, err = url.QueryUnescape(prefix)
+	if err != nil {
+		app.log.Printf("error in completion, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error completing given string. message: %s", err.Error()))
+	}
+
+	if len(prefix) > 300 {
+		return echo.NewHTTPError(http.StatusBadRequest, "error completing given string. message: too long input")
+	}
+
+	for _, r := range prefix {
+		if isLatinLetter(r) {
+			return echo.NewHTTPError(http.StatusBadRequest, "error completing given string. message: prefix should be in native script")
+		}
+	}
+
+	if v := c.QueryParam("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 || offset > maxCompletionOffset {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid offset")
+		}
+	}
+
+	if v := c.QueryParam("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxCompletionLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+	}
+
+	// Fetch one more to know whether there's a next page
+	result, err := getCompletions(c.Request().Context(), langCode, prefix, offset, limit+1)
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error in completion, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error completing given string. message: %s", err.Error()))
+	}
+
+	sugs := result.([]govarnamgo.Suggestion)
+	hasMore := len(sugs) > limit
+	if hasMore {
+		sugs = sugs[:limit]
+	}
+
+	words := toSuggestionResponses(sugs)
+	if words == nil {
+		words = []suggestionResponse{}
+	}
+
+	return c.JSON(http.StatusOK, completionResponse{
+		standardResponse: newStandardResponse(),
+		Input:            prefix,
+		Result:           words,
+		Offset:           offset,
+		Limit:            limit,
+		HasMore:          hasMore,
+	})
+}
+
+// handlePrediction returns the words most likely to follow ?after=
+// based on the word pairs learned for the scheme.
+func handlePrediction(c echo.Context) error {
+	var (
+		langCode = c.Param("langCode")
+		after    = strings.TrimSpace(c.QueryParam("after"))
+		app      = c.Get("app").(*App)
+		limit    = defaultPredictionLimit
+		err      error
+	)
+
+	if after == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "after is required")
+	}
+
+	if len(after) > 300 {
+		return echo.NewHTTPError(http.StatusBadRequest, "error predicting words. message: too long input")
+	}
+
+	if v := c.QueryParam("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxPredictionLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+	}
+
+	words, err := predictNextWords(c.Request().Context(), langCode, after, limit)
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error in prediction, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error predicting words. message: %s", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, predictionResponse{standardResponse: newStandardResponse(), After: after, Result: words})
+}
+
+// handleAdvancedTransliterationStream is the Server-Sent Events variant of
+// handleAdvancedTransliteration. Each category is sent as a separate event as
+// soon as it's computed, followed by a "done" event. greedy_tokenized comes
+// first, then the categories from the dictionary and tokenizer_suggestions last.
+func handleAdvancedTransliterationStream(c echo.Context) error {
+	var (
+		langCode = c.Param("langCode")
+		word     = c.Param("word")
+		app      = c.Get("app").(*App)
+	)
+
+	// Resolving a bug in echo
+	// https://github.com/labstack/echo/issues/561
+	var err error
+	word, err = url.QueryUnescape(word)
+	if err != nil {
+		app.log.Printf("error in transliterating, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: %s", err.Error()))
+	}
+
+	if len(word) > 300 {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: too long input"))
+	}
+
+	if _, ok := handlePools[langCode]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid scheme identifier")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("X-Accel-Buffering", "no")
+	c.Response().WriteHeader(http.StatusOK)
+
+	sendEvent := func(event string, data interface{}) {
+		b, _ := json.Marshal(data)
+		_, _ = fmt.Fprintf(c.Response(), "event: %s\ndata: %s\n\n", event, b)
+		c.Response().Flush()
+	}
+
+	sendCategories := func(categories []advancedCategory) {
+		for _, cat := range categories {
+			if cat.Suggestions == nil {
+				cat.Suggestions = []suggestionResponse{}
+			}
+			sendEvent(cat.Name, cat.Suggestions)
+		}
+	}
+
+	var cacheKey = app.cache.Key("atl", langCode, word)
+
+	if cached, err := app.cache.Get(cacheKey); err == nil {
+		response := cached.(advancedTransliterationResponse)
+		sendCategories(response.categories())
+		sendEvent("done", newStandardResponse())
+
+		return nil
+	}
+
+	ctx := c.Request().Context()
 
-		for _, sug := range varnamResult.ExactWords {
-			response.ExactWords = append(response.ExactWords, suggestionResponse(sug))
-		}
-		for _, sug := range varnamResult.ExactMatches {
-			response.ExactMatches = append(response.ExactMatches, suggestionResponse(sug))
-		}
-		for _, sug := range varnamResult.DictionarySuggestions {
-			response.DictionarySuggestions = append(response.DictionarySuggestions, suggestionResponse(sug))
-		}
-		for _, sug := range varnamResult.PatternDictionarySuggestions {
-			response.PatternDictionarySuggestions = append(response.PatternDictionarySuggestions, suggestionResponse(sug))
-		}
-		for _, sug := range varnamResult.TokenizerSuggestions {
-			response.TokenizerSuggestions = append(response.TokenizerSuggestions, suggestionResponse(sug))
+	_, err = getOrCreateHandler(ctx, langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+		var response advancedTransliterationResponse
+
+		// Greedy tokenization doesn't hit the dictionary, so it's the fastest
+		response.GreedyTokenized = toSuggestionResponses(handle.TransliterateGreedyTokenized(word))
+		sendCategories([]advancedCategory{{"greedy_tokenized", response.GreedyTokenized}})
+
+		// varnam computes the rest in a single call, so it's called once for the
+		// dictionary and once for the tokenizer, with the other one turned off.
+		defer handle.SetConfig(defaultVarnamConfig)
+
+		config := defaultVarnamConfig
+		config.TokenizerSuggestionsLimit = 0
+		handle.SetConfig(config)
+
+		result, err := handle.TransliterateAdvanced(ctx, word)
+		if err != nil {
+			return nil, err
 		}
-		for _, sug := range varnamResult.GreedyTokenized {
-			response.GreedyTokenized = append(response.GreedyTokenized, suggestionResponse(sug))
+
+		response.ExactWords = toSuggestionResponses(result.ExactWords)
+		response.ExactMatches = toSuggestionResponses(result.ExactMatches)
+		response.DictionarySuggestions = toSuggestionResponses(result.DictionarySuggestions)
+		response.PatternDictionarySuggestions = toSuggestionResponses(result.PatternDictionarySuggestions)
+
+		sendCategories([]advancedCategory{
+			{"exact_words", response.ExactWords},
+			{"exact_matches", response.ExactMatches},
+			{"dictionary_suggestions", response.DictionarySuggestions},
+			{"pattern_dictionary_suggestions", response.PatternDictionarySuggestions},
+		})
+
+		// Exact matches, which tokenizer suggestions depend on, aren't limited by the config
+		config = defaultVarnamConfig
+		config.DictionarySuggestionsLimit = 0
+		config.PatternDictionarySuggestionsLimit = 0
+		handle.SetConfig(config)
+
+		result, err = handle.TransliterateAdvanced(ctx, word)
+		if err != nil {
+			return nil, err
 		}
 
+		response.TokenizerSuggestions = toSuggestionResponses(result.TokenizerSuggestions)
+		sendCategories([]advancedCategory{{"tokenizer_suggestions", response.TokenizerSuggestions}})
+
 		_ = app.cache.Set(cacheKey, response)
-	}
 
-	response.Input = word
+		return nil, nil
+	})
+	if err != nil {
+		app.log.Printf("error in transliterating, err: %s", err.Error())
 
-	// Don't return null for array responses
-	if response.ExactWords == nil {
-		response.ExactWords = []suggestionResponse{}
-	}
-	if response.ExactMatches == nil {
-		response.ExactMatches = []suggestionResponse{}
-	}
-	if response.DictionarySuggestions == nil {
-		response.DictionarySuggestions = []suggestionResponse{}
-	}
-	if response.PatternDictionarySuggestions == nil {
-		response.PatternDictionarySuggestions = []suggestionResponse{}
-	}
-	if response.TokenizerSuggestions == nil {
-		response.TokenizerSuggestions = []suggestionResponse{}
-	}
-	if response.GreedyTokenized == nil {
-		response.GreedyTokenized = []suggestionResponse{}
+		resp := newStandardResponse()
+		resp.Success = false
+		resp.Error = err.Error()
+		sendEvent("error", resp)
+
+		return nil
 	}
 
-	response.standardResponse = newStandardResponse()
+	sendEvent("done", newStandardResponse())
 
-	return c.JSON(http.StatusOK, response)
+	return nil
 }
 
 func handleReverseTransliteration(c echo.Context) error {
@@ -254,11 +1336,15 @@ func handleReverseTransliteration(c echo.Context) error {
 	}
 
 	// Separate namespace for reverse transliteration
-	cacheKey := fmt.Sprintf("rtl-%s-%s", langCode, word)
+	cacheKey := app.cache.Key("rtl", langCode, word)
 
 	words, err := app.cache.GetString(cacheKey)
 	if err != nil {
-		result, err := reveseTransliterate(langCode, word)
+		result, err := reveseTransliterate(c.Request().Context(), langCode, word)
+		if errors.Is(err, errHandlePoolExhausted) {
+			return handlePoolExhaustedError(c)
+		}
+
 		if err != nil {
 			app.log.Printf("error in reverse transliterationg, err: %s", err.Error())
 			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error transliterating given string. message: %s", err.Error()))
@@ -395,7 +1481,7 @@ func handleLanguageDownload(c echo.Context) error {
 		langCode = c.Param("langCode")
 	)
 
-	filepath, err := getSchemeFilePath(langCode)
+	filepath, err := getSchemeFilePath(c.Request().Context(), langCode)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error: %s", err.Error()))
 	}
@@ -463,7 +1549,7 @@ func handleSchemeLetterDefinitions(c echo.Context) error {
 
 func handleLearn(c echo.Context) error {
 	var (
-		a args
+		a learnArgs
 
 		app = c.Get("app").(*App)
 	)
@@ -479,9 +1565,94 @@ func handleLearn(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "unable to find language")
 	}
 
-	go func(word string) { ch <- word }(a.Text)
+	if a.Weight < 0 || a.LearnedOn < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "weight and learned_on can't be negative")
+	}
 
-	return c.JSON(http.StatusOK, "success")
+	queue := learnQueues[a.LangCode]
+	if !queue.reserve(1) {
+		app.log.Printf("learn queue is full: %s", a.LangCode)
+		return queueFullError(c)
+	}
+
+	seq, err := journals[a.LangCode].append(journalEntry{Learn: a.Text, Weight: a.Weight, LearnedOn: a.LearnedOn, User: requestUser(c)})
+	if err != nil {
+		queue.release(1)
+		app.log.Printf("error writing learn journal, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "error saving the request")
+	}
+
+	job := newJob("learn", a.LangCode, len(strings.Fields(a.Text)))
+
+	// A slot is reserved, so this doesn't block
+	ch <- learnRequest{Text: a.Text, Weight: a.Weight, LearnedOn: a.LearnedOn, User: requestUser(c), Job: job, Seq: seq}
+
+	return c.JSON(http.StatusOK, jobResponse{standardResponse: newStandardResponse(), JobID: job.ID})
+}
+
+// handleLearnBulk is an endpoint for learning words with their weights in the following format.
+// [
+// 	{text, weight, learned_on},
+// 	{text, weight, learned_on}
+// ]
+// weight and learned_on are optional. Each item is queued like a /learn request.
+func handleLearnBulk(c echo.Context) error {
+	var (
+		bulkArgs []learnBulkArgs
+		app      = c.Get("app").(*App)
+		langCode = c.Param("langCode")
+	)
+
+	if err := c.Bind(&bulkArgs); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error getting metadata. message: %s", err.Error()))
+	}
+
+	ch, ok := learnChannels[langCode]
+	if !ok {
+		app.log.Printf("unknown language requested to learn: %s", langCode)
+		return echo.NewHTTPError(http.StatusBadRequest, "unable to find language")
+	}
+
+	var (
+		entries []journalEntry
+		words   int
+	)
+
+	for _, v := range bulkArgs {
+		if v.Weight < 0 || v.LearnedOn < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "weight and learned_on can't be negative")
+		}
+
+		entries = append(entries, journalEntry{Learn: v.Text, Weight: v.Weight, LearnedOn: v.LearnedOn, User: requestUser(c)})
+		words += len(strings.Fields(v.Text))
+	}
+
+	queue := learnQueues[langCode]
+	if len(entries) > queue.capacity {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("too many items, at most %d can be learned at once", queue.capacity))
+	}
+
+	if !queue.reserve(len(entries)) {
+		app.log.Printf("learn queue is full: %s", langCode)
+		return queueFullError(c)
+	}
+
+	first, err := journals[langCode].append(entries...)
+	if err != nil {
+		queue.release(len(entries))
+		app.log.Printf("error writing learn journal, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "error saving the request")
+	}
+
+	job := newJob("learn-bulk", langCode, words)
+
+	// Slots are reserved for all of them, so this doesn't block
+	for i, e := range entries {
+		ch <- learnRequest{Text: e.Learn, Weight: e.Weight, LearnedOn: e.LearnedOn, User: e.User, Job: job, Seq: first + uint64(i)}
+	}
+
+	return c.JSON(http.StatusOK, jobResponse{standardResponse: newStandardResponse(), JobID: job.ID})
 }
 
 func handleLearnFileUpload(c echo.Context) error {
@@ -508,42 +1679,67 @@ func handleLearnFileUpload(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "unable to find language to train")
 	}
 
-	// Copy files first
-	for _, file := range files {
-		// Source
-		src, err := file.Open()
-		if err != nil {
-			app.log.Printf("learn file upload error, err: %s", err.Error())
-			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-		}
+	// Files are saved before the request returns, and learned in the background
+	var saved []string
 
-		// Destination
-		tempDir, err := ioutil.TempDir(os.TempDir(), "varnamd")
-		if err != nil {
-			app.log.Printf("learn file upload error, err: %s", err.Error())
-			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	removeSaved := func() {
+		for _, f := range saved {
+			_ = os.RemoveAll(filepath.Dir(f))
 		}
+	}
 
-		dst, err := os.Create(filepath.Join(tempDir, file.Filename))
+	for _, file := range files {
+		f, err := saveUploadedFile(file)
 		if err != nil {
+			removeSaved()
 			app.log.Printf("learn file upload error, err: %s", err.Error())
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
 
-		// Copy
-		if _, err = io.Copy(dst, src); err != nil {
-			app.log.Printf("learn file upload error, err: %s", err.Error())
-			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-		}
+		saved = append(saved, f)
+	}
+
+	job := newJob("learn-file", langCode, 0)
+	job.start()
+
+	go app.learnFromFiles(langCode, saved, job)
+
+	return c.JSON(http.StatusOK, jobResponse{standardResponse: newStandardResponse(), JobID: job.ID})
+}
+
+// saveUploadedFile copies an uploaded file to a temporary directory of its own, keeping its name.
+func saveUploadedFile(file *multipart.FileHeader) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = src.Close() }()
+
+	tempDir, err := ioutil.TempDir(os.TempDir(), "varnamd")
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := os.Create(filepath.Join(tempDir, filepath.Base(file.Filename)))
+	if err != nil {
+		_ = os.RemoveAll(tempDir)
+		return "", err
+	}
 
-		// Explicitely closing resources.
+	if _, err = io.Copy(dst, src); err != nil {
 		_ = dst.Close()
-		_ = src.Close()
+		_ = os.RemoveAll(tempDir)
 
-		learnWordsFromFile(c, langCode, dst.Name(), true)
+		return "", err
 	}
 
-	return c.JSON(http.StatusOK, "success")
+	if err := dst.Close(); err != nil {
+		_ = os.RemoveAll(tempDir)
+		return "", err
+	}
+
+	return dst.Name(), nil
 }
 
 func handleTrain(c echo.Context) error {
@@ -566,12 +1762,25 @@ func handleTrain(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "unable to find language to train")
 	}
 
-	go func(args trainArgs) { ch <- args }(targs)
+	queue := trainQueues[langCode]
+	if !queue.reserve(1) {
+		app.log.Printf("train queue is full: %s", langCode)
+		return queueFullError(c)
+	}
+
+	seq, err := journals[langCode].append(journalEntry{Train: &targs, User: requestUser(c)})
+	if err != nil {
+		queue.release(1)
+		app.log.Printf("error writing learn journal, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "error saving the request")
+	}
 
-	cacheKey := fmt.Sprintf("tl-%s-%s", langCode, targs.Pattern)
-	_, _ = app.cache.Delete(cacheKey)
+	job := newJob("train", langCode, 1)
 
-	return c.JSON(200, "Word Trained")
+	// A slot is reserved, so this doesn't block
+	ch <- trainRequest{Args: targs, User: requestUser(c), Job: job, Seq: seq}
+
+	return c.JSON(200, jobResponse{standardResponse: newStandardResponse(), JobID: job.ID})
 }
 
 // handleTrainBulk is an endpoint for training words in the following format.
@@ -601,18 +1810,49 @@ func handleTrainBulk(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "unable to find language to train")
 	}
 
+	var entries []journalEntry
+
 	for _, v := range bulkArgs {
 		for _, p := range v.Pattern {
-			go func(args trainArgs) {
-				ch <- args
-			}(trainArgs{
-				Pattern: p,
-				Word:    v.Word,
-			})
+			entries = append(entries, journalEntry{Train: &trainArgs{Pattern: p, Word: v.Word}, User: requestUser(c)})
 		}
 	}
 
-	return c.JSON(200, "Words Trained")
+	queue := trainQueues[langCode]
+	if len(entries) > queue.capacity {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("too many patterns, at most %d can be trained at once", queue.capacity))
+	}
+
+	if !queue.reserve(len(entries)) {
+		app.log.Printf("train queue is full: %s", langCode)
+		return queueFullError(c)
+	}
+
+	first, err := journals[langCode].append(entries...)
+	if err != nil {
+		queue.release(len(entries))
+		app.log.Printf("error writing learn journal, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "error saving the request")
+	}
+
+	job := newJob("train-bulk", langCode, len(entries))
+
+	// Slots are reserved for all of them, so this doesn't block
+	for i, e := range entries {
+		ch <- trainRequest{Args: *e.Train, User: e.User, Job: job, Seq: first + uint64(i)}
+	}
+
+	return c.JSON(200, jobResponse{standardResponse: newStandardResponse(), JobID: job.ID})
+}
+
+// handleJob returns the status of an asynchronous learn or train job.
+func handleJob(c echo.Context) error {
+	job, ok := getJob(c.Param("id"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "job not found")
+	}
+
+	return c.JSON(http.StatusOK, jobStatusResponse{standardResponse: newStandardResponse(), Job: job})
 }
 
 // Delete a word
@@ -630,16 +1870,246 @@ func handleDelete(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error getting metadata. message: %s", err.Error()))
 	}
 
-	if _, err := deleteWord(a.LangCode, a.Text); err != nil {
+	_, err := deleteWord(c.Request().Context(), a.LangCode, a.Text)
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
 		app.log.Printf("error deleting word, err: %s", err.Error())
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error: %s", err.Error()))
 	}
 
-	app.cache.Clear()
+	invalidateLearnings(app.cache, a.LangCode)
 
 	return c.JSON(http.StatusOK, "success")
 }
 
+// handleDeletePattern removes a single pattern => word training, undoing a /train.
+func handleDeletePattern(c echo.Context) error {
+	var (
+		targs    trainArgs
+		app      = c.Get("app").(*App)
+		langCode = c.Param("langCode")
+	)
+
+	if err := c.Bind(&targs); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error reading request. message: %s", err.Error()))
+	}
+
+	if strings.TrimSpace(targs.Pattern) == "" || strings.TrimSpace(targs.Word) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "pattern and word are required")
+	}
+
+	removed, err := unlearnPattern(langCode, targs.Pattern, targs.Word)
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if errors.Is(err, errLearningsSchema) {
+		app.log.Printf("error deleting pattern, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("error deleting pattern. message: %s", err.Error()))
+	}
+
+	if err != nil {
+		app.log.Printf("error deleting pattern, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error: %s", err.Error()))
+	}
+
+	// The pattern is dropped right away, the rest of the results along with the learnings
+	invalidatePattern(app.cache, langCode, strings.TrimSpace(targs.Pattern))
+	invalidateLearnings(app.cache, langCode)
+
+	return c.JSON(http.StatusOK, deleteResponse{standardResponse: newStandardResponse(), Removed: removed})
+}
+
+// handleDeleteBulk unlearns a list of words.
+func handleDeleteBulk(c echo.Context) error {
+	var (
+		a        deleteBulkArgs
+		app      = c.Get("app").(*App)
+		langCode = c.Param("langCode")
+	)
+
+	if err := c.Bind(&a); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error reading request. message: %s", err.Error()))
+	}
+
+	removed, err := deleteWords(c.Request().Context(), langCode, a.Words)
+	if removed > 0 {
+		invalidateLearnings(app.cache, langCode)
+	}
+
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error deleting words, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error deleting words after removing %d. message: %s", removed, err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, deleteResponse{standardResponse: newStandardResponse(), Removed: removed})
+}
+
+// handleDeleteFilter unlearns the words learned within a time range or by a user.
+func handleDeleteFilter(c echo.Context) error {
+	var (
+		filter   wordFilter
+		app      = c.Get("app").(*App)
+		langCode = c.Param("langCode")
+	)
+
+	if err := c.Bind(&filter); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error reading request. message: %s", err.Error()))
+	}
+
+	// Deleting everything should be deliberate, not a missing field
+	if filter.LearnedAfter <= 0 && filter.LearnedBefore <= 0 && filter.User == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "one of learned_after, learned_before or user is required")
+	}
+
+	words, err := findWords(c.Request().Context(), langCode, filter)
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error finding words to delete, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error: %s", err.Error()))
+	}
+
+	removed, err := deleteWords(c.Request().Context(), langCode, words)
+	if removed > 0 {
+		invalidateLearnings(app.cache, langCode)
+	}
+
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error deleting words, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error deleting words after removing %d. message: %s", removed, err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, deleteResponse{standardResponse: newStandardResponse(), Removed: removed})
+}
+
+// handleCacheWarm precomputes the suggestions of common input patterns.
+func handleCacheWarm(c echo.Context) error {
+	var (
+		a        cacheWarmArgs
+		app      = c.Get("app").(*App)
+		langCode = c.Param("langCode")
+	)
+
+	if err := c.Bind(&a); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error getting metadata. message: %s", err.Error()))
+	}
+
+	if len(a.Patterns) > maxWarmPatterns {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("too many patterns, at most %d can be warmed at once", maxWarmPatterns))
+	}
+
+	var patterns []string
+	for _, p := range a.Patterns {
+		if p = strings.TrimSpace(p); p != "" && len(p) <= 300 {
+			patterns = append(patterns, p)
+		}
+	}
+
+	warmed, failed, err := warmCache(c.Request().Context(), app, langCode, patterns)
+	if errors.Is(err, errHandlePoolExhausted) {
+		return handlePoolExhaustedError(c)
+	}
+
+	if err != nil {
+		app.log.Printf("error warming cache, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error warming cache. message: %s", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, cacheWarmResponse{standardResponse: newStandardResponse(), Warmed: warmed, Failed: failed})
+}
+
+func handleCacheStats(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	s, ok := app.cache.(cacheStatsReporter)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "cache stats are not available for the configured cache backend")
+	}
+
+	return c.JSON(http.StatusOK, cacheStatsResponse{standardResponse: newStandardResponse(), cacheStats: s.Stats()})
+}
+
+// handleClusterStats reports how the sharded results were got on this peer.
+func handleClusterStats(c echo.Context) error {
+	if len(cacheGroups) == 0 {
+		return echo.NewHTTPError(http.StatusNotImplemented, "cache isn't sharded, there's no cluster configured")
+	}
+
+	return c.JSON(http.StatusOK, clusterStatsResponse{standardResponse: newStandardResponse(), Groups: clusterStats()})
+}
+
+// handleCacheFlush drops the cached results of a scheme.
+func handleCacheFlush(c echo.Context) error {
+	var (
+		langCode = c.Param("langCode")
+		app      = c.Get("app").(*App)
+	)
+
+	if !isValidSchemeIdentifier(langCode) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error flushing cache. message: %s is not a valid libvarnam supported scheme", langCode))
+	}
+
+	app.cache.InvalidateScheme(langCode)
+
+	return c.JSON(http.StatusOK, cacheFlushResponse{standardResponse: newStandardResponse(), Flushed: true})
+}
+
+// handleCacheFlushKey drops the cached result of a word. Results computed
+// with options are cached under other keys and are left alone. Results
+// sharded across a cluster can't be dropped one by one, so the whole
+// scheme is flushed for them instead.
+func handleCacheFlushKey(c echo.Context) error {
+	var (
+		a        cacheFlushKeyArgs
+		langCode = c.Param("langCode")
+		app      = c.Get("app").(*App)
+	)
+
+	if err := c.Bind(&a); err != nil {
+		app.log.Printf("error reading request, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error reading request. message: %s", err.Error()))
+	}
+
+	if !isValidSchemeIdentifier(langCode) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("error flushing cache. message: %s is not a valid libvarnam supported scheme", langCode))
+	}
+
+	if !isCacheNamespace(a.Namespace) || strings.TrimSpace(a.Word) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "error flushing cache. message: namespace should be one of tl, atl or rtl and word is required")
+	}
+
+	if _, ok := cacheGroups[a.Namespace]; ok {
+		app.cache.InvalidateScheme(langCode)
+		return c.JSON(http.StatusOK, cacheFlushResponse{standardResponse: newStandardResponse(), Flushed: true})
+	}
+
+	flushed, err := app.cache.Delete(app.cache.Key(a.Namespace, langCode, a.Word))
+	if err != nil {
+		app.log.Printf("error flushing cache, err: %s", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("error flushing cache. message: %s", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, cacheFlushResponse{standardResponse: newStandardResponse(), Flushed: flushed})
+}
+
 func toggleDownloadEnabledStatus(langCode string, status bool) (interface{}, error) {
 	if err := varnamdConfig.setDownloadStatus(langCode, status); err != nil {
 		return nil, err
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sseEvent is an event sent by a Server-Sent Events endpoint.
+type sseEvent struct {
+	name string
+	data string
+}
+
+func readEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+
+	var events []sseEvent
+
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var ev sseEvent
+
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+
+		if ev.name == "" {
+			t.Fatalf("invalid event %q", block)
+		}
+
+		events = append(events, ev)
+	}
+
+	return events
+}
+
+// Categories are streamed as they are computed, and add up to the response of /atl.
+func TestAdvancedTransliterationStream(t *testing.T) {
+	_, e := newTestServer(t)
+
+	// An exact word for the pattern
+	var job jobResponse
+	decodeResponse(t, doRequest(t, e, http.MethodPost, "/train/ml", trainArgs{Pattern: "karava", Word: "കരവ"}), &job)
+	waitForJob(t, e, job.JobID)
+
+	rec := doRequest(t, e, http.MethodGet, "/atl/ml/karava/stream", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var (
+		events   = readEvents(t, rec.Body.String())
+		want     = []string{"greedy_tokenized", "exact_words", "exact_matches", "dictionary_suggestions", "pattern_dictionary_suggestions", "tokenizer_suggestions", "done"}
+		streamed advancedTransliterationResponse
+	)
+
+	var names []string
+	for _, ev := range events {
+		names = append(names, ev.name)
+
+		if ev.name == "done" {
+			continue
+		}
+
+		if err := json.Unmarshal([]byte(ev.data), streamed.category(ev.name)); err != nil {
+			t.Fatalf("error decoding %s: %s", ev.name, err.Error())
+		}
+	}
+
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected events %v, got %v", want, names)
+	}
+
+	if len(streamed.ExactWords) == 0 || streamed.ExactWords[0].Word != "കരവ" {
+		t.Errorf("expected കരവ in the exact words, got %v", streamed.ExactWords)
+	}
+
+	// Computed again as results with options aren't the cached ones
+	var atl advancedTransliterationResponse
+	decodeResponse(t, doRequest(t, e, http.MethodGet, "/atl/ml/karava?include_weights=true", nil), &atl)
+
+	for _, cat := range atl.categories() {
+		if got := *streamed.category(cat.Name); !reflect.DeepEqual(got, cat.Suggestions) {
+			t.Errorf("expected %s to be %v as in /atl, got %v", cat.Name, cat.Suggestions, got)
+		}
+	}
+}
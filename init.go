@@ -82,6 +82,40 @@ func initVFS() (stuffbin.FileSystem, error) {
 	return fs, nil
 }
 
+// initCacheConfig reads the [cache] config. The cache is enabled
+// with freecache unless configured otherwise.
+func initCacheConfig() (cacheConfig, error) {
+	config := cacheConfig{
+		Enabled:          true,
+		Backend:          cacheBackendFreecache,
+		Size:             defaultCacheSize,
+		SnapshotInterval: defaultSnapshotInterval,
+		SnapshotSize:     defaultSnapshotSize,
+	}
+
+	if kf.Exists("cache") {
+		if err := kf.Unmarshal("cache", &config); err != nil {
+			return config, err
+		}
+	}
+
+	return config, nil
+}
+
+// initClusterConfig reads the [cluster] config. There's
+// no cluster unless peers are configured.
+func initClusterConfig() (clusterConfig, error) {
+	config := clusterConfig{Size: defaultClusterCacheSize, Timeout: defaultClusterTimeout}
+
+	if kf.Exists("cluster") {
+		if err := kf.Unmarshal("cluster", &config); err != nil {
+			return config, err
+		}
+	}
+
+	return config, nil
+}
+
 func initAppConfig() (appConfig, error) {
 	var config appConfig
 	// Read configuration using Koanf.
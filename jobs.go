@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Job statuses.
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+const (
+	// Finished jobs are forgotten after this long.
+	jobRetention = time.Hour
+	// Number of error messages kept for a job.
+	maxJobErrors = 100
+)
+
+// learnJob tracks an asynchronous learn or train request.
+// A job is done when every item is processed, and failed if none could be applied.
+type learnJob struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	LangCode  string    `json:"lang"`
+	Status    string    `json:"status"`
+	Total     int       `json:"total"`
+	Applied   int       `json:"applied"`
+	Failed    int       `json:"failed"`
+	Errors    []string  `json:"errors"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	mu sync.Mutex
+}
+
+// learnRequest is a text to learn queued for the learner of a scheme.
+type learnRequest struct {
+	Text      string
+	Weight    int
+	LearnedOn int64
+	User      string
+	Job       *learnJob
+	// Sequence number of the request in the journal
+	Seq uint64
+}
+
+// trainRequest is a pattern to train queued for the learner of a scheme.
+type trainRequest struct {
+	Args trainArgs
+	User string
+	Job  *learnJob
+	// Sequence number of the request in the journal
+	Seq uint64
+}
+
+var (
+	jobs   = make(map[string]*learnJob)
+	jobsMu sync.Mutex
+)
+
+// newJob registers a job of total items. Finished jobs past their retention are removed.
+func newJob(jobType string, langCode string, total int) *learnJob {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	now := time.Now()
+	job := &learnJob{
+		ID:        hex.EncodeToString(b),
+		Type:      jobType,
+		LangCode:  langCode,
+		Status:    jobQueued,
+		Total:     total,
+		Errors:    []string{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	for id, j := range jobs {
+		if j.finished() && now.Sub(j.updatedAt()) > jobRetention {
+			delete(jobs, id)
+		}
+	}
+
+	jobs[job.ID] = job
+
+	if total == 0 {
+		job.Status = jobDone
+	}
+
+	return job
+}
+
+// getJob returns a copy of the job with the id.
+func getJob(id string) (*learnJob, bool) {
+	jobsMu.Lock()
+	job, ok := jobs[id]
+	jobsMu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return &learnJob{
+		ID:        job.ID,
+		Type:      job.Type,
+		LangCode:  job.LangCode,
+		Status:    job.Status,
+		Total:     job.Total,
+		Applied:   job.Applied,
+		Failed:    job.Failed,
+		Errors:    append([]string{}, job.Errors...),
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}, true
+}
+
+// progress records applied and failed items of the job. Jobs replayed from
+// the journal after a restart have no job, so a nil job is ignored.
+func (j *learnJob) progress(applied int, failed int, errs ...string) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Applied += applied
+	j.Failed += failed
+
+	for _, e := range errs {
+		if len(j.Errors) < maxJobErrors {
+			j.Errors = append(j.Errors, e)
+		}
+	}
+
+	j.UpdatedAt = time.Now()
+
+	switch {
+	case j.Applied+j.Failed < j.Total:
+		j.Status = jobRunning
+	case j.Applied == 0 && j.Failed > 0:
+		j.Status = jobFailed
+	default:
+		j.Status = jobDone
+	}
+}
+
+// start marks a job whose total isn't known upfront, like learning from files, as running.
+func (j *learnJob) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Status = jobRunning
+	j.UpdatedAt = time.Now()
+}
+
+// add records items of a started job along with its total.
+func (j *learnJob) add(total int, failed int, errs ...string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Total += total
+	j.Applied += total - failed
+	j.Failed += failed
+
+	for _, e := range errs {
+		if len(j.Errors) < maxJobErrors {
+			j.Errors = append(j.Errors, e)
+		}
+	}
+
+	j.UpdatedAt = time.Now()
+}
+
+// finish marks a started job as done, or failed if nothing was applied.
+func (j *learnJob) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.Applied == 0 && (j.Failed > 0 || len(j.Errors) > 0) {
+		j.Status = jobFailed
+	} else {
+		j.Status = jobDone
+	}
+
+	j.UpdatedAt = time.Now()
+}
+
+func (j *learnJob) finished() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.Status == jobDone || j.Status == jobFailed
+}
+
+func (j *learnJob) updatedAt() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.UpdatedAt
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Entries written to a journal segment before a new one is started.
+	journalSegmentSize = 10000
+
+	journalSegmentExt = ".journal"
+	journalCheckpoint = "checkpoint"
+)
+
+// journalEntry is a learn or train request waiting to be applied.
+type journalEntry struct {
+	Seq       uint64     `json:"seq"`
+	Learn     string     `json:"learn,omitempty"`
+	Weight    int        `json:"weight,omitempty"`
+	LearnedOn int64      `json:"learned_on,omitempty"`
+	Train     *trainArgs `json:"train,omitempty"`
+	User      string     `json:"user,omitempty"`
+}
+
+// journal is an append-only log of the learn and train requests of a scheme.
+// Requests are written to it before they are queued, so that accepted words
+// are not lost on a restart.
+//
+// Entries are numbered and written to segments of journalSegmentSize entries.
+// The checkpoint is the sequence number up to which every entry has been
+// applied, and only entries after it are replayed on the next start. Segments
+// whose entries are all before the checkpoint are removed. Entries applied
+// while an earlier one is still pending, and entries applied right before a
+// crash, are after the checkpoint and are applied again on the next start.
+type journal struct {
+	mu  sync.Mutex
+	dir string
+
+	// Segment being written to and the number of entries in it.
+	file    *os.File
+	written int
+
+	// First sequence number of each segment on disk, the last one is being written to.
+	segments []uint64
+	// Sequence number of the next entry.
+	next uint64
+
+	checkpoint uint64
+	// Entries applied after the checkpoint, which can't move past entries still pending.
+	done map[uint64]bool
+
+	// Entries found in the journal when it was opened.
+	replay []journalEntry
+}
+
+var journals map[string]*journal
+
+// openJournal opens the journal of a scheme and reads the entries left
+// from the previous run.
+func openJournal(schemeIdentifier string) (*journal, error) {
+	dir := path.Join(getJournalDir(), schemeIdentifier)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	j := &journal{dir: dir, done: make(map[uint64]bool)}
+
+	checkpoint, err := j.readCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	j.checkpoint = checkpoint
+	j.next = checkpoint + 1
+
+	segments, err := j.listSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, start := range segments {
+		entries, err := readJournalSegment(j.segmentPath(start))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if e.Seq >= j.next {
+				j.next = e.Seq + 1
+			}
+
+			if e.Seq > j.checkpoint {
+				j.replay = append(j.replay, e)
+			}
+		}
+
+		if start > j.next {
+			j.next = start
+		}
+	}
+
+	sort.Slice(j.replay, func(a, b int) bool { return j.replay[a].Seq < j.replay[b].Seq })
+
+	// Numbers without an entry, like a partially written one, don't hold back the checkpoint
+	replayed := make(map[uint64]bool, len(j.replay))
+	for _, e := range j.replay {
+		replayed[e.Seq] = true
+	}
+
+	for seq := j.checkpoint + 1; seq < j.next; seq++ {
+		if !replayed[seq] {
+			j.done[seq] = true
+		}
+	}
+
+	// Entries are always written to a new segment, the old ones are only read
+	j.segments = segments
+	if err := j.rotate(); err != nil {
+		return nil, err
+	}
+
+	if err := j.prune(); err != nil {
+		_ = j.file.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// append writes the entries to disk and returns the sequence number of the
+// first one. The entries are numbered one after another. It returns after
+// the entries are synced.
+func (j *journal) append(entries ...journalEntry) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.written >= journalSegmentSize {
+		if err := j.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	var (
+		buf   []byte
+		first = j.next
+	)
+
+	for i, e := range entries {
+		e.Seq = first + uint64(i)
+
+		b, err := json.Marshal(e)
+		if err != nil {
+			return 0, err
+		}
+
+		buf = append(append(buf, b...), '\n')
+	}
+
+	if _, err := j.file.Write(buf); err != nil {
+		return 0, err
+	}
+
+	if err := j.file.Sync(); err != nil {
+		return 0, err
+	}
+
+	j.next += uint64(len(entries))
+	j.written += len(entries)
+
+	return first, nil
+}
+
+// applied marks entries as applied. The checkpoint is moved past every entry
+// applied so far without a pending entry before it, and segments which are
+// no longer needed are removed.
+func (j *journal) applied(seqs ...uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, seq := range seqs {
+		if seq > j.checkpoint {
+			j.done[seq] = true
+		}
+	}
+
+	checkpoint := j.checkpoint
+	for j.done[checkpoint+1] {
+		delete(j.done, checkpoint+1)
+		checkpoint++
+	}
+
+	if checkpoint == j.checkpoint {
+		return nil
+	}
+
+	if err := j.writeCheckpoint(checkpoint); err != nil {
+		return err
+	}
+
+	j.checkpoint = checkpoint
+
+	// Everything written is applied, so the segment can go as well
+	if j.checkpoint+1 == j.next && j.written > 0 {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	return j.prune()
+}
+
+// takeReplay returns the entries left from the previous run, only once.
+func (j *journal) takeReplay() []journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := j.replay
+	j.replay = nil
+
+	return entries
+}
+
+// rotate starts a new segment at the next sequence number.
+func (j *journal) rotate() error {
+	file, err := os.OpenFile(j.segmentPath(j.next), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	if j.file != nil {
+		_ = j.file.Close()
+	}
+
+	j.file = file
+	j.written = 0
+
+	if len(j.segments) == 0 || j.segments[len(j.segments)-1] != j.next {
+		j.segments = append(j.segments, j.next)
+	}
+
+	return nil
+}
+
+// prune removes the segments before the one being written to whose entries are all applied.
+// Entries of a segment come before the first entry of the segment after it.
+func (j *journal) prune() error {
+	for len(j.segments) > 1 && j.segments[1]-1 <= j.checkpoint {
+		if err := os.Remove(j.segmentPath(j.segments[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		j.segments = j.segments[1:]
+	}
+
+	return nil
+}
+
+func (j *journal) segmentPath(start uint64) string {
+	return path.Join(j.dir, fmt.Sprintf("%020d%s", start, journalSegmentExt))
+}
+
+// listSegments returns the first sequence numbers of the segments on disk in order.
+func (j *journal) listSegments() ([]uint64, error) {
+	files, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []uint64
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), journalSegmentExt) {
+			continue
+		}
+
+		start, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), journalSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		segments = append(segments, start)
+	}
+
+	sort.Slice(segments, func(a, b int) bool { return segments[a] < segments[b] })
+
+	return segments, nil
+}
+
+func (j *journal) readCheckpoint() (uint64, error) {
+	b, err := ioutil.ReadFile(path.Join(j.dir, journalCheckpoint))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// writeCheckpoint replaces the checkpoint file, so that a crash leaves either the old or the new one.
+func (j *journal) writeCheckpoint(checkpoint uint64) error {
+	var (
+		name = path.Join(j.dir, journalCheckpoint)
+		tmp  = name + ".tmp"
+	)
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.WriteString(strconv.FormatUint(checkpoint, 10) + "\n"); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+// readJournalSegment reads the entries of a segment.
+func readJournalSegment(name string) ([]journalEntry, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	var entries []journalEntry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var e journalEntry
+
+		// A partially written entry from a crash is skipped
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Seq == 0 {
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+func getJournalDir() string {
+	configDir := getConfigDir()
+	return path.Join(configDir, "journal")
+}
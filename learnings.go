@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/varnamproject/govarnam/govarnamgo"
+)
+
+var (
+	learningsDBs   = make(map[string]*sql.DB)
+	learningsDBsMu sync.Mutex
+
+	contributionStores   = make(map[string]*sql.DB)
+	contributionStoresMu sync.Mutex
+)
+
+// errLearningsSchema is returned when the learnings DB doesn't have the tables
+// and columns which are written to directly. The schema belongs to govarnam.
+var errLearningsSchema = errors.New("unexpected learnings DB schema")
+
+// patternsSchema are the tables and columns of the learnings DB unlearnPattern writes to.
+var patternsSchema = map[string][]string{
+	"patterns": {"pattern", "word_id"},
+	"words":    {"id", "word"},
+}
+
+// wordFilter selects learned words to be deleted. Timestamps are unix timestamps.
+type wordFilter struct {
+	LearnedAfter  int64  `json:"learned_after"`
+	LearnedBefore int64  `json:"learned_before"`
+	User          string `json:"user"`
+}
+
+// getLearningsPath returns the path of the learnings DB of a language, the same way govarnam finds it.
+func getLearningsPath(langCode string) string {
+	dir := os.Getenv("VARNAM_LEARNINGS_DIR")
+	if dir == "" {
+		if home := os.Getenv("XDG_DATA_HOME"); home != "" {
+			dir = path.Join(home, "varnam", "learnings")
+		} else {
+			dir = path.Join(os.Getenv("HOME"), ".local", "share", "varnam", "learnings")
+		}
+	}
+
+	return path.Join(dir, langCode+".vst.learnings")
+}
+
+// getLearningsDB opens the learnings DB of a scheme for what govarnam doesn't offer,
+// like looking up words and removing a single pattern. Words are only ever deleted
+// through govarnam since the DB keeps them in sync with an FTS5 index.
+// All the schemes of a language share one learnings DB.
+func getLearningsDB(schemeIdentifier string) (*sql.DB, error) {
+	langCode, ok := schemeLangCode(schemeIdentifier)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a valid libvarnam supported scheme", schemeIdentifier)
+	}
+
+	learningsDBsMu.Lock()
+	defer learningsDBsMu.Unlock()
+
+	if db, ok := learningsDBs[langCode]; ok {
+		return db, nil
+	}
+
+	db, err := sql.Open("sqlite3", getLearningsPath(langCode))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(1)
+	learningsDBs[langCode] = db
+
+	return db, nil
+}
+
+func schemeLangCode(schemeIdentifier string) (string, bool) {
+	for _, sd := range schemeDetails {
+		if sd.Identifier == schemeIdentifier {
+			return sd.LangCode, true
+		}
+	}
+
+	return "", false
+}
+
+// getContributionStore returns the store of who learned which words of a scheme,
+// creating it if required.
+func getContributionStore(schemeIdentifier string) (*sql.DB, error) {
+	contributionStoresMu.Lock()
+	defer contributionStoresMu.Unlock()
+
+	if db, ok := contributionStores[schemeIdentifier]; ok {
+		return db, nil
+	}
+
+	if !isValidSchemeIdentifier(schemeIdentifier) {
+		return nil, fmt.Errorf("%s is not a valid libvarnam supported scheme", schemeIdentifier)
+	}
+
+	if err := createContributionsDir(); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", path.Join(getContributionsDir(), schemeIdentifier+".contributions"))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(1)
+
+	q := `CREATE TABLE IF NOT EXISTS contributions (
+		word TEXT NOT NULL,
+		user TEXT NOT NULL,
+		learned_on INTEGER NOT NULL,
+		PRIMARY KEY (word, user)
+	);`
+
+	if _, err = db.Exec(q); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	contributionStores[schemeIdentifier] = db
+
+	return db, nil
+}
+
+// recordContributions remembers that the user learned the words, so that
+// they can be deleted by user later.
+func recordContributions(schemeIdentifier string, user string, words []string) error {
+	if user == "" || len(words) == 0 {
+		return nil
+	}
+
+	db, err := getContributionStore(schemeIdentifier)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO contributions (word, user, learned_on) VALUES (?, ?, strftime('%s', 'now'))
+		ON CONFLICT (word, user) DO UPDATE SET learned_on = excluded.learned_on`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	for _, word := range words {
+		if _, err := stmt.Exec(word, user); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// unlearnPattern removes a single pattern => word training and returns the number of entries removed.
+func unlearnPattern(schemeIdentifier string, pattern string, word string) (int, error) {
+	db, err := getLearningsDB(schemeIdentifier)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock := lockLearnings(schemeIdentifier)
+	defer unlock()
+
+	if err := checkLearningsSchema(db, patternsSchema); err != nil {
+		return 0, err
+	}
+
+	result, err := db.Exec("DELETE FROM patterns WHERE pattern = ? AND word_id IN (SELECT id FROM words WHERE word = ?)",
+		strings.TrimSpace(pattern), strings.TrimSpace(word))
+	if err != nil {
+		return 0, err
+	}
+
+	removed, err := result.RowsAffected()
+
+	return int(removed), err
+}
+
+// checkLearningsSchema makes sure that the tables of the learnings DB have the columns.
+func checkLearningsSchema(db *sql.DB, tables map[string][]string) error {
+	for table, columns := range tables {
+		rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+		if err != nil {
+			return err
+		}
+
+		found := make(map[string]bool)
+
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				_ = rows.Close()
+				return err
+			}
+
+			found[name] = true
+		}
+
+		err = rows.Err()
+		_ = rows.Close()
+
+		if err != nil {
+			return err
+		}
+
+		for _, col := range columns {
+			if !found[col] {
+				return fmt.Errorf("%w: %s.%s not found", errLearningsSchema, table, col)
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteWords unlearns the words which are learned and returns the number of words removed.
+func deleteWords(ctx context.Context, schemeIdentifier string, words []string) (int, error) {
+	db, err := getLearningsDB(schemeIdentifier)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+
+	_, err = getOrCreateHandler(ctx, schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+		unlock := lockLearnings(schemeIdentifier)
+		defer unlock()
+
+		var deleted []string
+
+		defer func() {
+			if ferr := forgetContributions(schemeIdentifier, deleted); ferr != nil && err == nil {
+				err = ferr
+			}
+		}()
+
+		for _, word := range words {
+			word = strings.TrimSpace(word)
+
+			// govarnam doesn't tell whether the word was there
+			var exists int
+			if err := db.QueryRow("SELECT COUNT(*) FROM words WHERE word = ?", word).Scan(&exists); err != nil {
+				return nil, err
+			}
+
+			if exists == 0 {
+				continue
+			}
+
+			if err := handle.Unlearn(word); err != nil {
+				return nil, err
+			}
+
+			count++
+			deleted = append(deleted, word)
+		}
+
+		return nil, nil
+	})
+
+	return count, err
+}
+
+// forgetContributions removes the deleted words from the contributions of every user.
+func forgetContributions(schemeIdentifier string, words []string) error {
+	if len(words) == 0 {
+		return nil
+	}
+
+	db, err := getContributionStore(schemeIdentifier)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, word := range words {
+		if _, err := tx.Exec("DELETE FROM contributions WHERE word = ?", word); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// findWords returns the learned words matching the filter. Words learned by a user
+// are found from the contributions, with the timestamps of the user's learning.
+func findWords(ctx context.Context, schemeIdentifier string, filter wordFilter) ([]string, error) {
+	var (
+		db    *sql.DB
+		err   error
+		query string
+		conds []string
+		args  []interface{}
+	)
+
+	if filter.User != "" {
+		db, err = getContributionStore(schemeIdentifier)
+		query = "SELECT word FROM contributions"
+		conds = append(conds, "user = ?")
+		args = append(args, filter.User)
+	} else {
+		db, err = getLearningsDB(schemeIdentifier)
+		query = "SELECT word FROM words"
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.LearnedAfter > 0 {
+		conds = append(conds, "learned_on > ?")
+		args = append(args, filter.LearnedAfter)
+	}
+
+	if filter.LearnedBefore > 0 {
+		conds = append(conds, "learned_on < ?")
+		args = append(args, filter.LearnedBefore)
+	}
+
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = rows.Close() }()
+
+	var words []string
+
+	for rows.Next() {
+		var word string
+		if err := rows.Scan(&word); err != nil {
+			return nil, err
+		}
+
+		words = append(words, word)
+	}
+
+	return words, rows.Err()
+}
+
+func createContributionsDir() error {
+	contributionsDir := getContributionsDir()
+	return os.MkdirAll(contributionsDir, 0750)
+}
+
+func getContributionsDir() string {
+	configDir := getConfigDir()
+	return path.Join(configDir, "contributions")
+}
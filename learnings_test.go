@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A trained pattern no longer gives its word once it's unlearned.
+func TestUnlearnPattern(t *testing.T) {
+	_, e := newTestServer(t)
+
+	// A word of its own so that only the pattern gives it
+	a := trainArgs{Pattern: "navaraa", Word: "പകല"}
+
+	var job jobResponse
+	decodeResponse(t, doRequest(t, e, http.MethodPost, "/train/ml", a), &job)
+	waitForJob(t, e, job.JobID)
+
+	var tl transliterationResponse
+	decodeResponse(t, doRequest(t, e, http.MethodGet, "/tl/ml/"+a.Pattern, nil), &tl)
+
+	if len(tl.Result) == 0 || tl.Result[0] != a.Word {
+		t.Fatalf("expected %s first for %s, got %v", a.Word, a.Pattern, tl.Result)
+	}
+
+	var resp deleteResponse
+	decodeResponse(t, doRequest(t, e, http.MethodPost, "/delete/pattern/ml", a), &resp)
+
+	if resp.Removed != 1 {
+		t.Fatalf("expected 1 pattern removed, got %d", resp.Removed)
+	}
+
+	decodeResponse(t, doRequest(t, e, http.MethodGet, "/tl/ml/"+a.Pattern, nil), &tl)
+
+	for _, w := range tl.Result {
+		if w == a.Word {
+			t.Fatalf("expected %s not to be in the results of %s once unlearned, got %v", a.Word, a.Pattern, tl.Result)
+		}
+	}
+}
+
+// Learnings DBs without the tables and columns written to are refused.
+func TestCheckLearningsSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "varnamd-schema-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "learnings"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = db.Close() }()
+
+	if _, err := db.Exec("CREATE TABLE words (id INTEGER PRIMARY KEY, word TEXT); CREATE TABLE patterns (pattern TEXT, word INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkLearningsSchema(db, patternsSchema); !errors.Is(err, errLearningsSchema) {
+		t.Fatalf("expected a schema error, got %v", err)
+	}
+
+	if _, err := db.Exec("ALTER TABLE patterns ADD COLUMN word_id INTEGER"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkLearningsSchema(db, patternsSchema); err != nil {
+		t.Fatalf("expected the schema to be accepted, got %s", err.Error())
+	}
+}
@@ -36,7 +36,11 @@ var (
 	// User accounts are stored here.
 	users map[string]userConfig
 
-	maxHandleCounts map[string]int
+	maxHandleCounts      map[string]int
+	handleCeilings       map[string]int
+	handleAcquireTimeout time.Duration
+	learnQueueSizes      map[string]int
+	learnWorkers         map[string]int
 )
 
 type appConfig struct {
@@ -47,6 +51,9 @@ type appConfig struct {
 	CertFilePath       string `koanf:"cert-path"`
 	KeyFilePath        string `koanf:"key-file-path"`
 
+	// Time a request waits for a varnam handle when all are in use.
+	HandleAcquireTimeout time.Duration `koanf:"handle-acquire-timeout"`
+
 	DownloadEnabledSchemes string        `koanf:"download-enabled-schemes"`
 	SyncInterval           time.Duration `koanf:"sync-interval"`
 	UpstreamURL            string        `koanf:"upstream-url"`
@@ -137,6 +144,23 @@ func main() {
 		maxHandleCounts["default"] = 10
 	}
 
+	handleCeilings = kf.IntMap("app.handle-ceiling")
+
+	handleAcquireTimeout = config.HandleAcquireTimeout
+	if handleAcquireTimeout <= 0 {
+		handleAcquireTimeout = defaultHandleAcquireTimeout
+	}
+
+	learnQueueSizes = kf.IntMap("app.learn-queue-size")
+	if learnQueueSizes["default"] <= 0 {
+		learnQueueSizes["default"] = defaultChanSize
+	}
+
+	learnWorkers = kf.IntMap("app.learn-workers")
+	if learnWorkers["default"] <= 0 {
+		learnWorkers["default"] = 1
+	}
+
 	authEnabled = kf.Bool("app.accounts-enabled")
 	if authEnabled {
 		if err = kf.Unmarshal("users", &users); err != nil {
@@ -154,13 +178,49 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
+	cacheConfig, err := initCacheConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	cache, err := NewCache(cacheConfig)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	app := &App{
-		cache: NewCache(),
+		cache: cache,
 		log:   log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile),
 		fs:    fs,
 	}
 
 	gob.Register(advancedTransliterationResponse{})
+	gob.Register([]suggestionResponse{})
+
+	clusterConfig, err := initClusterConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if len(clusterConfig.Peers) > 0 {
+		handler, err := initCluster(clusterConfig, app.cache)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		go serveCluster(app, clusterConfig.Address, handler)
+		app.log.Printf("sharding cache with %d peers as %s", len(clusterConfig.Peers), clusterConfig.Self)
+	}
+
+	if count, err := loadCacheSnapshot(app.cache); err != nil {
+		app.log.Printf("error loading cache snapshot, err: %s", err.Error())
+	} else if count > 0 {
+		app.log.Printf("loaded %d cache entries from snapshot", count)
+	}
+
+	if cacheConfig.SnapshotInterval > 0 && cacheConfig.SnapshotSize > 0 {
+		go app.snapshotCachePeriodically(cacheConfig.SnapshotInterval, cacheConfig.SnapshotSize)
+	}
 
 	startSyncDispatcher()
 	startDaemon(app, config)
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/knadh/stuffbin"
+	"github.com/labstack/echo/v4"
+	"github.com/varnamproject/govarnam/govarnam"
+)
+
+// Set in the process running the tests, see TestMain.
+const testFixturesEnv = "VARNAMD_TEST_FIXTURES"
+
+var (
+	testApp     *App
+	testServer  *echo.Echo
+	testSetup   sync.Once
+	testAddrSeq int32
+)
+
+// TestMain runs the tests against a small Malayalam scheme made for them.
+// libgovarnam reads the VST and learnings directories from the environment
+// when it's loaded, so the tests are run in a child process with them set.
+func TestMain(m *testing.M) {
+	if os.Getenv(testFixturesEnv) != "" {
+		// Registered by main for the cached results
+		gob.Register(advancedTransliterationResponse{})
+		gob.Register([]suggestionResponse{})
+
+		os.Exit(m.Run())
+	}
+
+	os.Exit(runWithFixtures())
+}
+
+func runWithFixtures() int {
+	dir, err := ioutil.TempDir("", "varnamd-test-")
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for _, d := range []string{"vst", "learnings", "home"} {
+		if err := os.MkdirAll(path.Join(dir, d), 0750); err != nil {
+			log.Println(err)
+			return 1
+		}
+	}
+
+	if err := makeTestVST(path.Join(dir, "vst", "ml.vst")); err != nil {
+		log.Printf("error making test scheme: %s", err.Error())
+		return 1
+	}
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		testFixturesEnv+"=1",
+		"VARNAM_VST_DIR="+path.Join(dir, "vst"),
+		"VARNAM_LEARNINGS_DIR="+path.Join(dir, "learnings"),
+		"HOME="+path.Join(dir, "home"),
+	)
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+
+		log.Println(err)
+		return 1
+	}
+
+	return 0
+}
+
+// makeTestVST makes a scheme with a few Malayalam vowels and consonants.
+func makeTestVST(vstPath string) error {
+	vm, err := govarnam.VMInit(vstPath)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = vm.Close() }()
+
+	vm.VSTMakerConfig.UseDeadConsonants = true
+
+	err = vm.VMSetSchemeDetails(govarnam.SchemeDetails{Identifier: "ml", LangCode: "ml", DisplayName: "Malayalam", IsStable: true})
+	if err != nil {
+		return err
+	}
+
+	token := func(pattern, value1, value2 string, symbolType int) error {
+		return vm.VMCreateToken(pattern, value1, value2, "", "", symbolType, govarnam.VARNAM_MATCH_EXACT, 0, govarnam.VARNAM_TOKEN_ACCEPT_ALL, false)
+	}
+
+	// Dead consonants are made with the virama, so it goes first
+	if err := token("~", "്", "", govarnam.VARNAM_SYMBOL_VIRAMA); err != nil {
+		return err
+	}
+
+	vowels := [][3]string{
+		{"a", "അ", ""}, {"aa", "ആ", "ാ"}, {"i", "ഇ", "ി"},
+		{"u", "ഉ", "ു"}, {"e", "എ", "െ"}, {"o", "ഒ", "ൊ"},
+	}
+
+	for _, v := range vowels {
+		if err := token(v[0], v[1], v[2], govarnam.VARNAM_SYMBOL_VOWEL); err != nil {
+			return err
+		}
+	}
+
+	consonants := [][2]string{
+		{"ka", "ക"}, {"ga", "ഗ"}, {"ma", "മ"}, {"la", "ല"}, {"ra", "ര"}, {"na", "ന"},
+		{"pa", "പ"}, {"ta", "ത"}, {"va", "വ"}, {"sa", "സ"}, {"ya", "യ"}, {"da", "ദ"},
+	}
+
+	for _, c := range consonants {
+		if err := token(c[0], c[1], "", govarnam.VARNAM_SYMBOL_CONSONANT); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newTestServer returns the server shared by the tests, with the internal APIs enabled.
+// Tests share the learnings, so each test should use words of its own.
+func newTestServer(t *testing.T) (*App, *echo.Echo) {
+	t.Helper()
+
+	testSetup.Do(func() {
+		maxHandleCounts = map[string]int{"default": 2}
+		handleAcquireTimeout = defaultHandleAcquireTimeout
+		learnQueueSizes = map[string]int{"default": defaultChanSize}
+		learnWorkers = map[string]int{"default": 2}
+
+		fs, err := stuffbin.NewFS()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cache, err := NewCache(cacheConfig{Enabled: true, Size: 16})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testApp = &App{cache: cache, log: log.New(ioutil.Discard, "", 0), fs: fs}
+
+		initHandlePools()
+		testApp.initChannels()
+
+		testServer = initHandlers(testApp, true)
+	})
+
+	return testApp, testServer
+}
+
+// doRequest sends a request to the server. body is sent as JSON, or as plain text if it's a reader.
+func doRequest(t *testing.T, e *echo.Echo, method string, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var (
+		r           io.Reader
+		contentType = echo.MIMEApplicationJSON
+	)
+
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		r, contentType = b, echo.MIMETextPlain
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, target, r)
+	req.Header.Set(echo.HeaderContentType, contentType)
+
+	return serveRequest(e, req)
+}
+
+// serveRequest sends a request built by the test to the server.
+func serveRequest(e *echo.Echo, req *http.Request) *httptest.ResponseRecorder {
+	// Every request comes from an address of its own so that the rate limiter doesn't kick in
+	n := atomic.AddInt32(&testAddrSeq, 1)
+	req.RemoteAddr = fmt.Sprintf("10.%d.%d.%d:1234", (n>>16)&0xff, (n>>8)&0xff, n&0xff)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// decodeResponse reads the JSON body of a successful response into v.
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("error decoding response %s: %s", rec.Body.String(), err.Error())
+	}
+}
+
+// waitForJob waits until a learn or train job is done.
+func waitForJob(t *testing.T, e *echo.Echo, id string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for time.Now().Before(deadline) {
+		var resp jobStatusResponse
+		decodeResponse(t, doRequest(t, e, http.MethodGet, "/jobs/"+id, nil), &resp)
+
+		switch resp.Job.Status {
+		case jobDone:
+			return
+		case jobFailed:
+			t.Fatalf("job %s failed: %v", id, resp.Job.Errors)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s isn't done yet", id)
+}
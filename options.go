@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/varnamproject/govarnam/govarnamgo"
+)
+
+// advancedCategoryNames are the categories of an advancedTransliterationResponse
+// in the order they are serialized. Names are the same as the JSON keys.
+var advancedCategoryNames = []string{
+	"exact_words",
+	"exact_matches",
+	"dictionary_suggestions",
+	"pattern_dictionary_suggestions",
+	"tokenizer_suggestions",
+	"greedy_tokenized",
+}
+
+// transliterationOptions are the query parameters accepted by /tl and /atl
+// to trim down the suggestions returned.
+type transliterationOptions struct {
+	Limit          int
+	Categories     []string
+	MinWeight      int
+	IncludeWeights bool
+
+	// Custom is set when any of the options were given in the request.
+	Custom bool
+}
+
+// parseTransliterationOptions reads ?limit=, ?categories=, ?min_weight= and ?include_weights=
+// from the request. includeWeights is the default for ?include_weights=.
+func parseTransliterationOptions(c echo.Context, includeWeights bool) (transliterationOptions, error) {
+	opts := transliterationOptions{IncludeWeights: includeWeights}
+
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid limit: %s", v)
+		}
+
+		opts.Limit = n
+		opts.Custom = true
+	}
+
+	if v := c.QueryParam("min_weight"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid min_weight: %s", v)
+		}
+
+		opts.MinWeight = n
+		opts.Custom = true
+	}
+
+	if v := c.QueryParam("include_weights"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid include_weights: %s", v)
+		}
+
+		opts.IncludeWeights = b
+		opts.Custom = true
+	}
+
+	if v := c.QueryParam("categories"); v != "" {
+		requested := make(map[string]bool)
+		for _, name := range strings.Split(v, ",") {
+			// Trailing or repeated commas
+			if name = strings.TrimSpace(name); name != "" {
+				requested[name] = true
+			}
+		}
+
+		// Keep the categories in the canonical order so that the cache key
+		// doesn't depend on the order in the request.
+		for _, name := range advancedCategoryNames {
+			if requested[name] {
+				opts.Categories = append(opts.Categories, name)
+				delete(requested, name)
+			}
+		}
+
+		for name := range requested {
+			return opts, fmt.Errorf("invalid category: %s", name)
+		}
+
+		if len(opts.Categories) > 0 {
+			opts.Custom = true
+		}
+	}
+
+	return opts, nil
+}
+
+// cacheKey is a canonical representation of the options to be used in cache keys.
+func (o transliterationOptions) cacheKey() string {
+	return fmt.Sprintf("opts(limit=%d,categories=%s,min_weight=%d,weights=%t)",
+		o.Limit, strings.Join(o.Categories, ","), o.MinWeight, o.IncludeWeights)
+}
+
+func (o transliterationOptions) includes(category string) bool {
+	if len(o.Categories) == 0 {
+		return true
+	}
+
+	for _, name := range o.Categories {
+		if name == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply drops suggestions below the minimum weight and trims the rest to the limit.
+func (o transliterationOptions) apply(sugs []suggestionResponse) []suggestionResponse {
+	result := []suggestionResponse{}
+
+	for _, sug := range sugs {
+		if o.Limit > 0 && len(result) >= o.Limit {
+			break
+		}
+
+		if sug.Weight >= o.MinWeight {
+			result = append(result, sug)
+		}
+	}
+
+	return result
+}
+
+// filterAdvanced applies the options to every category of the response.
+// Categories which are not requested are removed.
+func (o transliterationOptions) filterAdvanced(r advancedTransliterationResponse) advancedTransliterationResponse {
+	for _, name := range advancedCategoryNames {
+		sugs := r.category(name)
+
+		if o.includes(name) {
+			*sugs = o.apply(*sugs)
+		} else {
+			*sugs = nil
+		}
+	}
+
+	return r
+}
+
+// serializeAdvanced builds the JSON body for an advanced transliteration
+// with only the requested categories.
+func (o transliterationOptions) serializeAdvanced(r advancedTransliterationResponse) map[string]interface{} {
+	out := map[string]interface{}{
+		"success": r.Success,
+		"error":   r.Error,
+		"at":      r.At,
+		"input":   r.Input,
+	}
+
+	for _, cat := range r.categories() {
+		if !o.includes(cat.Name) {
+			continue
+		}
+
+		if cat.Suggestions == nil {
+			cat.Suggestions = []suggestionResponse{}
+		}
+
+		if o.IncludeWeights {
+			out[cat.Name] = cat.Suggestions
+		} else {
+			out[cat.Name] = suggestionWords(cat.Suggestions)
+		}
+	}
+
+	return out
+}
+
+func suggestionWords(sugs []suggestionResponse) []string {
+	words := []string{}
+	for _, sug := range sugs {
+		words = append(words, sug.Word)
+	}
+
+	return words
+}
+
+// transliterateWithOptions is the /tl lookup used when options are given.
+// When categories are requested, the suggestions of those categories are
+// merged in order from an advanced transliteration.
+func transliterateWithOptions(ctx context.Context, app *App, langCode string, word string, opts transliterationOptions) ([]suggestionResponse, error) {
+	cacheKey := app.cache.Key("tl", langCode, opts.cacheKey(), word)
+
+	if cached, err := app.cache.Get(cacheKey); err == nil {
+		// gob decodes empty slices as nil
+		if sugs := cached.([]suggestionResponse); sugs != nil {
+			return sugs, nil
+		}
+
+		return []suggestionResponse{}, nil
+	}
+
+	var sugs []suggestionResponse
+
+	if len(opts.Categories) > 0 {
+		result, err := transliterateAdvanced(ctx, langCode, word)
+		if err != nil {
+			return nil, err
+		}
+
+		response := toAdvancedTransliterationResponse(result.(govarnamgo.TransliterationResult))
+		seen := make(map[string]bool)
+
+		for _, cat := range response.categories() {
+			if !opts.includes(cat.Name) {
+				continue
+			}
+
+			for _, sug := range cat.Suggestions {
+				if !seen[sug.Word] {
+					seen[sug.Word] = true
+					sugs = append(sugs, sug)
+				}
+			}
+		}
+	} else {
+		result, err := transliterate(ctx, langCode, word)
+		if err != nil {
+			return nil, err
+		}
+
+		sugs = toSuggestionResponses(result.([]govarnamgo.Suggestion))
+	}
+
+	sugs = opts.apply(sugs)
+	_ = app.cache.Set(cacheKey, sugs)
+
+	return sugs, nil
+}
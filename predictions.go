@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// Word pairs are written to the store in transactions of this size.
+const bigramsPerTransaction = 1000
+
+var (
+	predictionStores   = make(map[string]*sql.DB)
+	predictionStoresMu sync.Mutex
+)
+
+type bigram struct {
+	First  string
+	Second string
+}
+
+// getPredictionStore returns the word pair store of a scheme, creating it if required.
+// Word pairs are kept in a sidecar SQLite database since govarnam only learns words.
+func getPredictionStore(schemeIdentifier string) (*sql.DB, error) {
+	predictionStoresMu.Lock()
+	defer predictionStoresMu.Unlock()
+
+	if db, ok := predictionStores[schemeIdentifier]; ok {
+		return db, nil
+	}
+
+	if !isValidSchemeIdentifier(schemeIdentifier) {
+		return nil, fmt.Errorf("%s is not a valid libvarnam supported scheme", schemeIdentifier)
+	}
+
+	if err := createPredictionsDir(); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", path.Join(getPredictionsDir(), schemeIdentifier+".bigrams"))
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows only one writer at a time
+	db.SetMaxOpenConns(1)
+
+	q := `CREATE TABLE IF NOT EXISTS bigrams (
+		first TEXT NOT NULL,
+		second TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 0,
+		learned_on INTEGER NOT NULL,
+		PRIMARY KEY (first, second)
+	);`
+
+	if _, err = db.Exec(q); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	predictionStores[schemeIdentifier] = db
+
+	return db, nil
+}
+
+// bigramsFromText returns the pairs of adjacent native words in text.
+// Anything other than whitespace between two words breaks the pair.
+func bigramsFromText(text string) []bigram {
+	var (
+		pairs []bigram
+		prev  string
+	)
+
+	for _, t := range tokenizeText(text) {
+		switch t.Kind {
+		case tokenNative:
+			if prev != "" {
+				pairs = append(pairs, bigram{prev, t.Text})
+			}
+			prev = t.Text
+		case tokenSpace:
+		default:
+			prev = ""
+		}
+	}
+
+	return pairs
+}
+
+// learnBigrams records the co-occurrence of word pairs for a scheme.
+func learnBigrams(schemeIdentifier string, pairs []bigram) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	db, err := getPredictionStore(schemeIdentifier)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(pairs); start += bigramsPerTransaction {
+		end := start + bigramsPerTransaction
+		if end > len(pairs) {
+			end = len(pairs)
+		}
+
+		if err := insertBigrams(db, pairs[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func insertBigrams(db *sql.DB, pairs []bigram) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO bigrams (first, second, count, learned_on) VALUES (?, ?, 1, strftime('%s', 'now'))
+		ON CONFLICT (first, second) DO UPDATE SET count = count + 1, learned_on = excluded.learned_on`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	for _, p := range pairs {
+		if _, err := stmt.Exec(p.First, p.Second); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// learnBigramsFromFile records the word pairs found in each line of a file
+// and returns the number of pairs learned.
+func learnBigramsFromFile(schemeIdentifier string, filePath string) (int, error) {
+	file, err := os.Open(filepath.Clean(filePath))
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	var (
+		scanner = bufio.NewScanner(file)
+		pairs   []bigram
+		count   int
+	)
+
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		pairs = append(pairs, bigramsFromText(scanner.Text())...)
+
+		if len(pairs) >= bigramsPerTransaction {
+			if err := learnBigrams(schemeIdentifier, pairs); err != nil {
+				return count, err
+			}
+
+			count += len(pairs)
+			pairs = pairs[:0]
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+
+	if err := learnBigrams(schemeIdentifier, pairs); err != nil {
+		return count, err
+	}
+
+	return count + len(pairs), nil
+}
+
+// predictNextWords returns the words most often seen after the given word.
+func predictNextWords(ctx context.Context, schemeIdentifier string, after string, limit int) ([]suggestionResponse, error) {
+	db, err := getPredictionStore(schemeIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT second, count, learned_on FROM bigrams WHERE first = ? ORDER BY count DESC, learned_on DESC LIMIT ?", after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = rows.Close() }()
+
+	words := []suggestionResponse{}
+
+	for rows.Next() {
+		var sug suggestionResponse
+		if err := rows.Scan(&sug.Word, &sug.Weight, &sug.LearnedOn); err != nil {
+			return nil, err
+		}
+
+		words = append(words, sug)
+	}
+
+	return words, rows.Err()
+}
+
+func createPredictionsDir() error {
+	predictionsDir := getPredictionsDir()
+	return os.MkdirAll(predictionsDir, 0750)
+}
+
+func getPredictionsDir() string {
+	configDir := getConfigDir()
+	return path.Join(configDir, "predictions")
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/varnamproject/govarnam/govarnamgo"
+)
+
+// Romanization styles for reverse transliteration of text.
+const (
+	// styleScheme is the input pattern of the scheme, as given by govarnam.
+	styleScheme = "scheme"
+	// styleISO is close to ISO 15919 with diacritics.
+	styleISO = "iso"
+	// styleCasual is how people type Manglish & co. on a phone.
+	styleCasual = "casual"
+)
+
+// The Brahmic scripts in Unicode share the same layout within their blocks,
+// so a letter is looked up by its offset in the block. Only the letters in
+// the symbol table of a scheme are romanized this way, see isoLettersOf.
+var (
+	indicBlocks = []rune{
+		0x0900, // Devanagari
+		0x0980, // Bengali
+		0x0A00, // Gurmukhi
+		0x0A80, // Gujarati
+		0x0B00, // Oriya
+		0x0B80, // Tamil
+		0x0C00, // Telugu
+		0x0C80, // Kannada
+		0x0D00, // Malayalam
+	}
+
+	isoVowels = map[rune]string{
+		0x01: "m̐", 0x02: "ṁ", 0x03: "ḥ", 0x3D: "'",
+		0x05: "a", 0x06: "ā", 0x07: "i", 0x08: "ī", 0x09: "u", 0x0A: "ū",
+		0x0B: "r̥", 0x0C: "l̥", 0x0D: "ê", 0x0E: "e", 0x0F: "ē", 0x10: "ai",
+		0x11: "ô", 0x12: "o", 0x13: "ō", 0x14: "au", 0x60: "r̥̄", 0x61: "l̥̄",
+		0x66: "0", 0x67: "1", 0x68: "2", 0x69: "3", 0x6A: "4",
+		0x6B: "5", 0x6C: "6", 0x6D: "7", 0x6E: "8", 0x6F: "9",
+	}
+
+	isoConsonants = map[rune]string{
+		0x15: "k", 0x16: "kh", 0x17: "g", 0x18: "gh", 0x19: "ṅ",
+		0x1A: "c", 0x1B: "ch", 0x1C: "j", 0x1D: "jh", 0x1E: "ñ",
+		0x1F: "ṭ", 0x20: "ṭh", 0x21: "ḍ", 0x22: "ḍh", 0x23: "ṇ",
+		0x24: "t", 0x25: "th", 0x26: "d", 0x27: "dh", 0x28: "n", 0x29: "ṉ",
+		0x2A: "p", 0x2B: "ph", 0x2C: "b", 0x2D: "bh", 0x2E: "m",
+		0x2F: "y", 0x30: "r", 0x31: "ṟ", 0x32: "l", 0x33: "ḷ", 0x34: "ḻ", 0x35: "v",
+		0x36: "ś", 0x37: "ṣ", 0x38: "s", 0x39: "h",
+	}
+
+	isoVowelSigns = map[rune]string{
+		0x3E: "ā", 0x3F: "i", 0x40: "ī", 0x41: "u", 0x42: "ū", 0x43: "r̥", 0x44: "r̥̄",
+		0x45: "ê", 0x46: "e", 0x47: "ē", 0x48: "ai", 0x49: "ô", 0x4A: "o", 0x4B: "ō", 0x4C: "au",
+		0x57: "au", 0x62: "l̥", 0x63: "l̥̄",
+	}
+
+	// Malayalam consonants without an inherent vowel, the chillu letters and the dot reph.
+	// Other blocks have different letters at these offsets.
+	isoMalayalamDeadConsonants = map[rune]string{
+		0x4E: "r", 0x54: "m", 0x55: "y", 0x56: "ḻ",
+		0x7A: "ṇ", 0x7B: "n", 0x7C: "r", 0x7D: "l", 0x7E: "ḷ", 0x7F: "k",
+	}
+
+	// Symbol types whose letters must all be romanizable for a scheme to support the iso style.
+	isoLetterSymbolTypes = map[int]bool{
+		1: true, // Vowel
+		2: true, // Consonant
+		3: true, // Dead consonant
+		4: true, // Consonant with a vowel sign
+		7: true, // Anusvara
+		8: true, // Visarga
+		9: true, // Virama
+	}
+
+	// Letters of the schemes, by scheme identifier. A nil map means
+	// the scheme doesn't support the iso style.
+	isoSchemeLetters   = make(map[string]map[rune]isoLetter)
+	isoSchemeLettersMu sync.Mutex
+)
+
+const (
+	malayalamBlock = 0x0D00
+
+	indicVirama = 0x4D
+	indicNukta  = 0x3C
+)
+
+// Kinds of isoLetter.
+const (
+	isoKindVowel = iota
+	isoKindConsonant
+	isoKindVowelSign
+	isoKindDeadConsonant
+	isoKindVirama
+	isoKindNukta
+)
+
+// isoLetter is the ISO 15919 romanization of a native letter.
+type isoLetter struct {
+	kind  int
+	roman string
+}
+
+var errStyleNotSupported = errors.New("style isn't supported by the scheme")
+
+func isValidRomanizationStyle(style string) bool {
+	return style == styleScheme || style == styleISO || style == styleCasual
+}
+
+// lookupISOLetter returns the ISO 15919 romanization of r from its offset in its Brahmic script block.
+func lookupISOLetter(r rune) (isoLetter, bool) {
+	for _, block := range indicBlocks {
+		if r < block || r >= block+0x80 {
+			continue
+		}
+
+		off := r - block
+
+		if s, ok := isoConsonants[off]; ok {
+			return isoLetter{isoKindConsonant, s}, true
+		}
+
+		if s, ok := isoVowelSigns[off]; ok {
+			return isoLetter{isoKindVowelSign, s}, true
+		}
+
+		switch off {
+		case indicVirama:
+			return isoLetter{kind: isoKindVirama}, true
+		case indicNukta:
+			return isoLetter{kind: isoKindNukta}, true
+		}
+
+		if block == malayalamBlock {
+			if s, ok := isoMalayalamDeadConsonants[off]; ok {
+				return isoLetter{isoKindDeadConsonant, s}, true
+			}
+		}
+
+		if s, ok := isoVowels[off]; ok {
+			return isoLetter{isoKindVowel, s}, true
+		}
+
+		return isoLetter{}, false
+	}
+
+	return isoLetter{}, false
+}
+
+// isoLettersOf returns the ISO 15919 romanization of the letters in the symbol table of a scheme.
+// It returns errStyleNotSupported if a letter of the scheme can't be romanized.
+func isoLettersOf(scheme string) (map[rune]isoLetter, error) {
+	isoSchemeLettersMu.Lock()
+	letters, ok := isoSchemeLetters[scheme]
+	isoSchemeLettersMu.Unlock()
+
+	if ok {
+		if letters == nil {
+			return nil, errStyleNotSupported
+		}
+
+		return letters, nil
+	}
+
+	symbolsI, err := searchSymbolTable(context.Background(), scheme, govarnamgo.NewSearchSymbol())
+	if err != nil {
+		return nil, err
+	}
+
+	letters = make(map[rune]isoLetter)
+
+	for _, sym := range symbolsI.([]govarnamgo.Symbol) {
+		if !isoLetterSymbolTypes[sym.Type] {
+			continue
+		}
+
+		for _, r := range sym.Value1 + sym.Value2 {
+			if r == '\u200c' || r == '\u200d' {
+				continue
+			}
+
+			l, ok := lookupISOLetter(r)
+			if !ok {
+				letters = nil
+				break
+			}
+
+			letters[r] = l
+		}
+
+		if letters == nil {
+			break
+		}
+	}
+
+	isoSchemeLettersMu.Lock()
+	isoSchemeLetters[scheme] = letters
+	isoSchemeLettersMu.Unlock()
+
+	if letters == nil {
+		return nil, errStyleNotSupported
+	}
+
+	return letters, nil
+}
+
+// isoRomanize romanizes a native word close to ISO 15919 with the letters of a scheme.
+// Anything which isn't a letter of the scheme is kept as is.
+func isoRomanize(letters map[rune]isoLetter, word string) string {
+	var (
+		b strings.Builder
+		// A consonant was written and is waiting for its vowel.
+		inherent bool
+	)
+
+	flush := func() {
+		if inherent {
+			b.WriteString("a")
+			inherent = false
+		}
+	}
+
+	for _, r := range word {
+		l, ok := letters[r]
+		if !ok {
+			flush()
+			if r != '\u200c' && r != '\u200d' {
+				b.WriteRune(r)
+			}
+			continue
+		}
+
+		switch l.kind {
+		case isoKindConsonant:
+			flush()
+			b.WriteString(l.roman)
+			inherent = true
+		case isoKindVowelSign:
+			inherent = false
+			b.WriteString(l.roman)
+		case isoKindVirama:
+			inherent = false
+		case isoKindNukta:
+		default:
+			flush()
+			b.WriteString(l.roman)
+		}
+	}
+
+	flush()
+
+	return b.String()
+}
+
+// casualRomanize turns a scheme pattern into the way it's casually typed:
+// lowercase, no special characters and no long vowels spelled out with "aa".
+func casualRomanize(pattern string) string {
+	var b strings.Builder
+
+	for _, r := range strings.ToLower(pattern) {
+		if r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		}
+	}
+
+	return strings.ReplaceAll(b.String(), "aa", "a")
+}
+
+// reverseTransliterateWords reverse transliterates every word using the rtl- cache where possible.
+// Cache misses are reverse transliterated with one borrowed handle. Words which
+// can't be reverse transliterated are left out of the result.
+func reverseTransliterateWords(ctx context.Context, app *App, langCode string, words []string) (map[string][]string, error) {
+	var (
+		results = make(map[string][]string, len(words))
+		pending []string
+	)
+
+	for _, word := range words {
+		if _, ok := results[word]; ok {
+			continue
+		}
+
+		sugs, err := app.cache.GetString(app.cache.Key("rtl", langCode, word))
+		if err != nil {
+			results[word] = nil
+			pending = append(pending, word)
+			continue
+		}
+
+		results[word] = sugs
+	}
+
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	_, err := getOrCreateHandler(ctx, langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+		for _, word := range pending {
+			result, rerr := handle.ReverseTransliterate(word)
+			if rerr != nil {
+				app.log.Printf("error in reverse transliterating, word: %s, err: %s", word, rerr.Error())
+				delete(results, word)
+				continue
+			}
+
+			sugs := []string{}
+			for _, sug := range result {
+				sugs = append(sugs, sug.Word)
+			}
+
+			_ = app.cache.SetString(app.cache.Key("rtl", langCode, word), sugs...)
+			results[word] = sugs
+		}
+
+		return nil, nil
+	})
+
+	return results, err
+}
+
+// romanizeWords romanizes native words in the given style.
+func romanizeWords(ctx context.Context, app *App, langCode string, words []string, style string) (map[string][]string, error) {
+	if style == styleISO {
+		letters, err := isoLettersOf(langCode)
+		if err != nil {
+			return nil, err
+		}
+
+		results := make(map[string][]string, len(words))
+		for _, word := range words {
+			results[word] = []string{isoRomanize(letters, word)}
+		}
+
+		return results, nil
+	}
+
+	results, err := reverseTransliterateWords(ctx, app, langCode, words)
+	if err != nil || style != styleCasual {
+		return results, err
+	}
+
+	for word, patterns := range results {
+		var (
+			casual []string
+			seen   = make(map[string]bool)
+		)
+
+		for _, p := range patterns {
+			if c := casualRomanize(p); c != "" && !seen[c] {
+				seen[c] = true
+				casual = append(casual, c)
+			}
+		}
+
+		results[word] = casual
+	}
+
+	return results, nil
+}
@@ -13,7 +13,7 @@ import (
 )
 
 func startDaemon(app *App, cfg appConfig) {
-	initLanguageChannels()
+	initHandlePools()
 	app.initChannels()
 
 	e := initHandlers(app, cfg.EnableInternalApis)
@@ -34,8 +34,19 @@ func startDaemon(app *App, cfg appConfig) {
 func initHandlers(app *App, enableInternalApis bool) *echo.Echo {
 	e := echo.New()
 	e.GET("/tl/:langCode/:word", handleTransliteration)
+	e.POST("/tl/:langCode/batch", handleBatchTransliteration)
+	e.POST("/tl/:langCode/text", handleTextTransliteration)
+	e.POST("/tl/:langCode/document", handleDocumentTransliteration)
 	e.GET("/rtl/:langCode/:word", handleReverseTransliteration)
+	e.POST("/rtl/:langCode/text", handleReverseTextTransliteration)
+	e.GET("/rtl/auto/:word", handleAutoReverseTransliteration)
+	e.POST("/detect", handleDetect)
+	e.POST("/spellcheck/:langCode", handleSpellcheck)
 	e.GET("/atl/:langCode/:word", handleAdvancedTransliteration)
+	e.GET("/atl/:langCode/:word/stream", handleAdvancedTransliterationStream)
+	e.GET("/ws/tl/:langCode", authUser(handleTransliterationSocket))
+	e.GET("/complete/:langCode/:prefix", handleCompletion)
+	e.GET("/predict/:langCode", handlePrediction)
 	// e.GET("/meta/:langCode:", handleMetadata)
 	// e.GET("/download/:langCode/:downloadStart", handleDownload)
 	e.GET("/languages", handleLanguages)
@@ -46,6 +57,8 @@ func initHandlers(app *App, enableInternalApis bool) *echo.Echo {
 	e.GET("/packs/:langCode/:packIdentifier/:packPageIdentifier", handlePackPageInfo)
 	e.GET("/packs/:langCode/:packIdentifier/:packPageIdentifier/download", handlePacksDownload)
 	e.GET("/status", handleStatus)
+	e.GET("/cache/stats", handleCacheStats)
+	e.GET("/cache/cluster", handleClusterStats)
 
 	e.GET("/schemes/:schemeID", handleSchemeInfo)
 	e.GET("/schemes/:schemeID/definitions", handleSchemeDefinitions)
@@ -60,10 +73,18 @@ func initHandlers(app *App, enableInternalApis bool) *echo.Echo {
 		e.POST("/sync/download/:langCode/disable", handleDisableDownload)
 
 		e.POST("/learn", authUser(handleLearn))
+		e.POST("/learn/bulk/:langCode", authUser(handleLearnBulk))
 		e.POST("/learn/upload/:langCode", authUser(handleLearnFileUpload))
 		e.POST("/train/:langCode", authUser(handleTrain))
 		e.POST("/train/bulk/:langCode", authUser(handleTrainBulk))
+		e.GET("/jobs/:id", authUser(handleJob))
 		e.POST("/delete", authUser(handleDelete))
+		e.POST("/delete/pattern/:langCode", authUser(handleDeletePattern))
+		e.POST("/delete/bulk/:langCode", authUser(handleDeleteBulk))
+		e.POST("/delete/filter/:langCode", authUser(handleDeleteFilter))
+		e.POST("/cache/warm/:langCode", authUser(handleCacheWarm))
+		e.POST("/cache/flush/:langCode", authUser(handleCacheFlush))
+		e.POST("/cache/flush/:langCode/key", authUser(handleCacheFlushKey))
 		e.POST("/packs/download", handlePackDownloadRequest)
 	}
 
@@ -152,8 +173,17 @@ func authUser(next echo.HandlerFunc) echo.HandlerFunc {
 				app.log.Printf("password mismatch")
 				return echo.NewHTTPError(http.StatusUnauthorized, "authorization failed, password mismatch")
 			}
+
+			// Words learned are recorded against the user
+			c.Set("user", strings.TrimSpace(authCreds[0]))
 		}
 
 		return next(c)
 	}
 }
+
+// requestUser returns the user authenticated by authUser, if any.
+func requestUser(c echo.Context) string {
+	user, _ := c.Get("user").(string)
+	return user
+}
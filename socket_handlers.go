@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/varnamproject/govarnam/govarnamgo"
+	"golang.org/x/net/websocket"
+)
+
+// Transliterations running at once for a socket. Further inputs
+// are read only once one of them finishes.
+const maxSocketInflight = 4
+
+// socketRequest is a message sent by the client over the transliteration socket.
+// A newer message with the same ID supersedes the older one.
+type socketRequest struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+type socketResponse struct {
+	ID     string   `json:"id"`
+	Input  string   `json:"input"`
+	Result []string `json:"result"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// inflight is a transliteration running for a request ID.
+type inflight struct {
+	seq    int
+	cancel context.CancelFunc
+}
+
+// handleTransliterationSocket streams suggestions over a WebSocket for live typing.
+// Every incoming input cancels the in-flight transliteration for the same ID.
+func handleTransliterationSocket(c echo.Context) error {
+	var (
+		langCode = c.Param("langCode")
+		app      = c.Get("app").(*App)
+	)
+
+	if _, ok := handlePools[langCode]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid scheme identifier")
+	}
+
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer func() { _ = ws.Close() }()
+
+		var (
+			ctx, cancelAll = context.WithCancel(c.Request().Context())
+			mu             sync.Mutex // guards running and writes to ws
+			running        = make(map[string]inflight)
+			seq            int
+			slots          = make(chan struct{}, maxSocketInflight)
+		)
+
+		defer cancelAll()
+
+		send := func(resp socketResponse) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err := websocket.JSON.Send(ws, resp); err != nil {
+				app.log.Printf("error writing to socket, err: %s", err.Error())
+			}
+		}
+
+		for {
+			var req socketRequest
+			if err := websocket.JSON.Receive(ws, &req); err != nil {
+				return
+			}
+
+			if len(req.Text) > 300 {
+				send(socketResponse{ID: req.ID, Input: req.Text, Result: []string{}, Error: "too long input"})
+				continue
+			}
+
+			reqCtx, cancel := context.WithCancel(ctx)
+
+			mu.Lock()
+			if prev, ok := running[req.ID]; ok {
+				prev.cancel()
+			}
+			seq++
+			running[req.ID] = inflight{seq: seq, cancel: cancel}
+			current := seq
+			mu.Unlock()
+
+			// Waits for a running transliteration to finish when there are too many
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				cancel()
+				return
+			}
+
+			go func(req socketRequest) {
+				defer func() { <-slots }()
+
+				words, err := socketTransliterate(reqCtx, app, langCode, req.Text)
+
+				mu.Lock()
+				r, ok := running[req.ID]
+				superseded := !ok || r.seq != current
+				if !superseded {
+					delete(running, req.ID)
+				}
+				mu.Unlock()
+				cancel()
+
+				// Superseded by a newer input or the client went away.
+				if superseded || ctx.Err() != nil {
+					return
+				}
+
+				resp := socketResponse{ID: req.ID, Input: req.Text, Result: words}
+				if err != nil {
+					resp.Result = []string{}
+					resp.Error = err.Error()
+				}
+
+				send(resp)
+			}(req)
+		}
+	}).ServeHTTP(c.Response(), c.Request())
+
+	return nil
+}
+
+func socketTransliterate(ctx context.Context, app *App, langCode string, word string) ([]string, error) {
+	cacheKey := app.cache.Key("tl", langCode, word)
+
+	words, err := app.cache.GetString(cacheKey)
+	if err == nil {
+		return words, nil
+	}
+
+	result, err := transliterate(ctx, langCode, word)
+	if err != nil {
+		return nil, err
+	}
+
+	words = []string{}
+	for _, sug := range result.([]govarnamgo.Suggestion) {
+		words = append(words, sug.Word)
+	}
+
+	_ = app.cache.SetString(cacheKey, words...)
+
+	return words, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/varnamproject/govarnam/govarnamgo"
+)
+
+const (
+	// Number of reverse transliterated patterns of a word used to look up corrections.
+	maxSpellcheckPatterns = 2
+	// Number of corrections suggested for a misspelled word.
+	maxSpellcheckSuggestions = 5
+)
+
+// spellcheckIssue is a word which is not in the learned dictionary.
+// Start and End are character (rune) offsets in the input.
+type spellcheckIssue struct {
+	Word        string               `json:"word"`
+	Start       int                  `json:"start"`
+	End         int                  `json:"end"`
+	Suggestions []suggestionResponse `json:"suggestions"`
+}
+
+// checkSpelling looks up a native word in the dictionary by reverse transliterating it
+// and transliterating the patterns back. If the word is not found among the dictionary
+// results, the other results are returned as corrections ranked by weight.
+func checkSpelling(ctx context.Context, handle *govarnamgo.VarnamHandle, word string) (bool, []suggestionResponse, error) {
+	// A word which can't be reverse transliterated can't be in the dictionary
+	// either, so it's reported as misspelled without corrections.
+	patterns, err := handle.ReverseTransliterate(word)
+	if err != nil {
+		return false, nil, nil
+	}
+
+	if len(patterns) > maxSpellcheckPatterns {
+		patterns = patterns[:maxSpellcheckPatterns]
+	}
+
+	var (
+		corrections []suggestionResponse
+		seen        = map[string]bool{word: true}
+	)
+
+	for _, pattern := range patterns {
+		result, err := handle.TransliterateAdvanced(ctx, pattern.Word)
+		if err != nil {
+			return false, nil, err
+		}
+
+		dictionary := [][]govarnamgo.Suggestion{
+			result.ExactWords,
+			result.ExactMatches,
+			result.DictionarySuggestions,
+			result.PatternDictionarySuggestions,
+		}
+
+		for _, sugs := range dictionary {
+			for _, sug := range sugs {
+				if sug.Word == word {
+					return true, nil, nil
+				}
+			}
+		}
+
+		for _, sugs := range append(dictionary, result.TokenizerSuggestions) {
+			for _, sug := range sugs {
+				if !seen[sug.Word] {
+					seen[sug.Word] = true
+					corrections = append(corrections, suggestionResponse(sug))
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(corrections, func(i, j int) bool {
+		return corrections[i].Weight > corrections[j].Weight
+	})
+
+	if len(corrections) > maxSpellcheckSuggestions {
+		corrections = corrections[:maxSpellcheckSuggestions]
+	}
+
+	return false, corrections, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -135,19 +136,23 @@ func downloadAllWords(langCode string, corpusSize int, output chan string) {
 	close(output)
 }
 
-func learnAll(langCode string, filesToLearn chan string) {
+func learnAll(cache Cache, langCode string, filesToLearn chan string) {
 	for fileToLearn := range filesToLearn {
-		learnFromFile(langCode, fileToLearn)
+		learnFromFile(cache, langCode, fileToLearn)
 	}
 }
 
-func learnFromFile(langCode, fileToLearn string) {
+func learnFromFile(cache Cache, langCode, fileToLearn string) {
 	start := time.Now()
 
 	log.Printf("Learning from %s\n", fileToLearn)
 
-	_, _ = getOrCreateHandler(langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+	_, _ = getOrCreateHandler(context.Background(), langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+		unlock := lockLearnings(langCode)
 		learnStatus, verr := handle.LearnFromFile(fileToLearn)
+		unlock()
+
+		invalidateLearnings(cache, langCode)
 
 		end := time.Now()
 		if verr != nil {
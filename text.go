@@ -0,0 +1,139 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Token kinds produced by tokenizeText.
+const (
+	tokenWord        = "word"
+	tokenSpace       = "space"
+	tokenPunctuation = "punctuation"
+	tokenNumber      = "number"
+	tokenURL         = "url"
+	tokenEmail       = "email"
+	tokenNative      = "native"
+)
+
+var (
+	urlPattern   = regexp.MustCompile(`^(?:[A-Za-z][A-Za-z0-9+.-]*://|www\.)\S+`)
+	emailPattern = regexp.MustCompile(`^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// textToken is a piece of the input text. Start and End are character
+// (rune) offsets in the input, OutputStart and OutputEnd are the offsets
+// of the best guess in the transliterated text.
+type textToken struct {
+	Text        string   `json:"text"`
+	Kind        string   `json:"kind"`
+	Start       int      `json:"start"`
+	End         int      `json:"end"`
+	OutputStart int      `json:"output_start"`
+	OutputEnd   int      `json:"output_end"`
+	Result      []string `json:"result"`
+	// Set when the word couldn't be transliterated and is kept as is.
+	Error string `json:"error,omitempty"`
+}
+
+func isNativeRune(r rune) bool {
+	// ZWJ and ZWNJ are part of words in several Indic scripts.
+	if r == '\u200c' || r == '\u200d' {
+		return true
+	}
+
+	return r > unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsMark(r))
+}
+
+func isLatinLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func isDigit(r rune) bool {
+	return unicode.IsDigit(r)
+}
+
+// matchSpecial checks whether a URL or an email starts at the beginning of runes
+// and returns its kind and length in runes.
+func matchSpecial(runes []rune) (string, int) {
+	end := 0
+	for end < len(runes) && !unicode.IsSpace(runes[end]) {
+		end++
+	}
+
+	chunk := string(runes[:end])
+
+	if m := urlPattern.FindString(chunk); m != "" {
+		// Trailing punctuation usually belongs to the sentence.
+		m = strings.TrimRight(m, ".,;:!?)]}'\"")
+		return tokenURL, len([]rune(m))
+	}
+
+	if m := emailPattern.FindString(chunk); m != "" {
+		return tokenEmail, len([]rune(m))
+	}
+
+	return "", 0
+}
+
+// tokenizeText splits text into words to be transliterated and everything
+// else which has to be kept as is.
+func tokenizeText(text string) []textToken {
+	var (
+		runes  = []rune(text)
+		tokens []textToken
+	)
+
+	add := func(kind string, start, end int) {
+		tokens = append(tokens, textToken{Text: string(runes[start:end]), Kind: kind, Start: start, End: end})
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		j := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+			add(tokenSpace, i, j)
+
+		case isLatinLetter(r) || isDigit(r):
+			if kind, n := matchSpecial(runes[i:]); n > 0 {
+				j = i + n
+				add(kind, i, j)
+				break
+			}
+
+			if isDigit(r) {
+				// Numbers like 1,000 and 3.14 are a single token.
+				for j < len(runes) && (isDigit(runes[j]) ||
+					((runes[j] == '.' || runes[j] == ',') && j+1 < len(runes) && isDigit(runes[j+1]))) {
+					j++
+				}
+				add(tokenNumber, i, j)
+				break
+			}
+
+			for j < len(runes) && isLatinLetter(runes[j]) {
+				j++
+			}
+			add(tokenWord, i, j)
+
+		case isNativeRune(r):
+			for j < len(runes) && isNativeRune(runes[j]) {
+				j++
+			}
+			add(tokenNative, i, j)
+
+		default:
+			add(tokenPunctuation, i, j)
+		}
+
+		i = j
+	}
+
+	return tokens
+}
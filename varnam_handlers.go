@@ -3,11 +3,10 @@ package main
 import (
 	"context"
 	"errors"
-	"log"
 	"sync"
-	"time"
 
 	"github.com/golang/groupcache"
+	"github.com/golang/groupcache/singleflight"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/varnamproject/govarnam/govarnamgo"
 )
@@ -19,13 +18,21 @@ type word struct {
 }
 
 var (
-	languageChannels    map[string]chan *govarnamgo.VarnamHandle
-	channelsCount       map[string]int
-	mutex               *sync.Mutex
+	handlePools         map[string]*handlePool
 	once                sync.Once
 	schemeDetails, errB = govarnamgo.GetAllSchemeDetails()
 	cacheGroups         = make(map[string]*groupcache.Group)
-	// peers            = groupcache.NewHTTPPool("http://localhost")
+
+	// computations coalesces concurrent computations of the same cache key.
+	computations singleflight.Group
+
+	// defaultVarnamConfig is the config govarnam initializes handles with.
+	// Handles which are reconfigured temporarily are reset to this.
+	defaultVarnamConfig = govarnamgo.Config{
+		DictionarySuggestionsLimit:        5,
+		PatternDictionarySuggestionsLimit: 5,
+		TokenizerSuggestionsLimit:         10,
+	}
 )
 
 func isValidSchemeIdentifier(id string) bool {
@@ -46,71 +53,66 @@ func getMaxHandleCount(schemeIdentifier string) int {
 	}
 }
 
-func initLanguageChannels() {
-	languageChannels = make(map[string]chan *govarnamgo.VarnamHandle)
-	channelsCount = make(map[string]int)
-	mutex = &sync.Mutex{}
+func getHandleCeiling(schemeIdentifier string) int {
+	if val, ok := handleCeilings[schemeIdentifier]; ok && val > 0 {
+		return val
+	}
 
-	for _, scheme := range schemeDetails {
-		maxHandleCount := getMaxHandleCount(scheme.Identifier)
-		languageChannels[scheme.Identifier] = make(chan *govarnamgo.VarnamHandle, maxHandleCount)
-		channelsCount[scheme.Identifier] = maxHandleCount
+	if val := handleCeilings["default"]; val > 0 {
+		return val
+	}
 
-		for i := 0; i < maxHandleCount; i++ {
-			handle, err := govarnamgo.InitFromID(scheme.Identifier)
-			if err != nil {
-				panic("Unable to init varnam for language" + scheme.LangCode + ". " + err.Error())
-			}
+	// Up to as many handles again as the pool size
+	return 2 * getMaxHandleCount(schemeIdentifier)
+}
+
+func initHandlePools() {
+	handlePools = make(map[string]*handlePool)
 
-			languageChannels[scheme.Identifier] <- handle
+	for _, scheme := range schemeDetails {
+		pool, err := newHandlePool(scheme.Identifier, getMaxHandleCount(scheme.Identifier), getHandleCeiling(scheme.Identifier), handleAcquireTimeout)
+		if err != nil {
+			panic("Unable to init varnam for language" + scheme.LangCode + ". " + err.Error())
 		}
+
+		handlePools[scheme.Identifier] = pool
 	}
 }
 
-func getOrCreateHandler(schemeIdentifier string, f func(handle *govarnamgo.VarnamHandle) (data interface{}, err error)) (data interface{}, err error) {
-	ch, ok := languageChannels[schemeIdentifier]
+func getOrCreateHandler(ctx context.Context, schemeIdentifier string, f func(handle *govarnamgo.VarnamHandle) (data interface{}, err error)) (data interface{}, err error) {
+	pool, ok := handlePools[schemeIdentifier]
 	if !ok {
 		return nil, errors.New("invalid scheme identifier")
 	}
 
-	select {
-	case handle := <-ch:
-		data, err = f(handle)
-
-		go func() { ch <- handle }()
-	case <-time.After(800 * time.Millisecond):
-		var handle *govarnamgo.VarnamHandle
-
-		handle, err = govarnamgo.InitFromID(schemeIdentifier)
-		if err != nil {
-			log.Println(err)
-			return nil, errors.New("unable to initialize varnam handle")
-		}
-
-		data, err = f(handle)
-		if err != nil {
-			log.Println(err)
-			return nil, errors.New("unable to complete varnam handle")
-		}
-
-		go sendHandlerToChannel(schemeIdentifier, handle, ch)
+	handle, err := pool.acquire(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return
+	defer pool.release(handle)
+
+	return f(handle)
 }
 
 func transliterate(c context.Context, schemeIdentifier string, word string) (interface{}, error) {
-	return getOrCreateHandler(schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+	return getOrCreateHandler(c, schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
 		return handle.Transliterate(c, word)
 	})
 }
 
 func transliterateAdvanced(c context.Context, schemeIdentifier string, word string) (interface{}, error) {
-	return getOrCreateHandler(schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+	return getOrCreateHandler(c, schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
 		return handle.TransliterateAdvanced(c, word)
 	})
 }
 
+// coalesce runs fn once for all the concurrent callers of a key and gives
+// every caller its result. Results are shared, so callers must not modify them.
+func coalesce(key string, fn func() (interface{}, error)) (interface{}, error) {
+	return computations.Do(key, fn)
+}
+
 // func trainwords(schemeIdentifier, word, pattern string) (interface{}, error) {
 // 	return getOrCreateHandler(schemeIdentifier, func(handle *libvarnam.Varnam) (data interface{}, err error) {
 // 		return handle.Train(pattern,word)
@@ -164,48 +166,53 @@ func transliterateAdvanced(c context.Context, schemeIdentifier string, word stri
 // 	return words, nil
 // }
 
-func reveseTransliterate(schemeIdentifier string, word string) (interface{}, error) {
-	return getOrCreateHandler(schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+func reveseTransliterate(ctx context.Context, schemeIdentifier string, word string) (interface{}, error) {
+	return getOrCreateHandler(ctx, schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
 		return handle.ReverseTransliterate(word)
 	})
 }
 
-func sendHandlerToChannel(schemeIdentifier string, handle *govarnamgo.VarnamHandle, ch chan *govarnamgo.VarnamHandle) {
-	mutex.Lock()
-	count := channelsCount[schemeIdentifier]
-	mutex.Unlock()
+// getCompletions returns learned words starting with the given native prefix, ordered by weight.
+func getCompletions(ctx context.Context, schemeIdentifier string, prefix string, offset int, limit int) (interface{}, error) {
+	return getOrCreateHandler(ctx, schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+		// Dictionary suggestions are limited by the handle config, so raise
+		// it for this lookup and reset it before the handle is given back.
+		config := defaultVarnamConfig
+		config.DictionarySuggestionsLimit = offset + limit
+		handle.SetConfig(config)
 
-	if count == getMaxHandleCount(schemeIdentifier) {
-		log.Printf("Throw away handle")
-		handle.Close()
+		defer handle.SetConfig(defaultVarnamConfig)
 
-		return
-	}
+		sugs, err := handle.GetSuggestions(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
 
-	select {
-	case ch <- handle:
-		mutex.Lock()
-		count = channelsCount[schemeIdentifier]
-		channelsCount[schemeIdentifier] = count + 1
-		mutex.Unlock()
-	default:
-	}
+		if len(sugs) <= offset {
+			return []govarnamgo.Suggestion{}, nil
+		}
+
+		return sugs[offset:], nil
+	})
 }
 
-func getSchemeFilePath(schemeIdentifier string) (interface{}, error) {
-	return getOrCreateHandler(schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+func getSchemeFilePath(ctx context.Context, schemeIdentifier string) (interface{}, error) {
+	return getOrCreateHandler(ctx, schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
 		return handle.GetVSTPath(), nil
 	})
 }
 
-func deleteWord(schemeIdentifier string, word string) (interface{}, error) {
-	return getOrCreateHandler(schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+func deleteWord(ctx context.Context, schemeIdentifier string, word string) (interface{}, error) {
+	return getOrCreateHandler(ctx, schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+		unlock := lockLearnings(schemeIdentifier)
+		defer unlock()
+
 		return nil, handle.Unlearn(word)
 	})
 }
 
 func searchSymbolTable(ctx context.Context, schemeIdentifier string, searchCondition govarnamgo.Symbol) (interface{}, error) {
-	return getOrCreateHandler(schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+	return getOrCreateHandler(ctx, schemeIdentifier, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
 		return handle.SearchSymbolTable(ctx, searchCondition), err
 	})
 }
@@ -1,92 +1,529 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/varnamproject/govarnam/govarnamgo"
 )
 
-const defaultChanSize = 1000
+const (
+	defaultChanSize = 1000
+
+	// Number of queued words a learner worker learns at once.
+	learnBatchSize = 500
+
+	// Weight govarnam gives to a word when it's learned for the first time.
+	learnedWordMinWeight = 30
+
+	// Seconds a client is asked to wait when a learn queue is full.
+	queueRetryAfter = 5
+)
 
 var (
-	learnChannels map[string]chan string
-	trainChannel  map[string]chan trainArgs
+	learnChannels map[string]chan learnRequest
+	trainChannel  map[string]chan trainRequest
+
+	learnQueues map[string]*learnQueue
+	trainQueues map[string]*learnQueue
+
+	learningsLocks map[string]*sync.Mutex
 )
 
+// learnQueue tracks the requests waiting in a learn or train channel so that
+// requests can be rejected when the channel is full instead of blocking.
+type learnQueue struct {
+	mu       sync.Mutex
+	depth    int
+	capacity int
+}
+
+// reserve takes n slots in the queue. It fails if there isn't room for all of them.
+func (q *learnQueue) reserve(n int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.depth+n > q.capacity {
+		return false
+	}
+
+	q.depth += n
+
+	return true
+}
+
+// release frees n slots after requests are taken from the channel.
+func (q *learnQueue) release(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.depth -= n
+	if q.depth < 0 {
+		q.depth = 0
+	}
+}
+
+func (q *learnQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.depth
+}
+
+func getLearnQueueSize(schemeIdentifier string) int {
+	if val, ok := learnQueueSizes[schemeIdentifier]; ok && val > 0 {
+		return val
+	}
+
+	return learnQueueSizes["default"]
+}
+
+// queueFullError asks the client to retry a learn or train request later.
+func queueFullError(c echo.Context) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(queueRetryAfter))
+	return echo.NewHTTPError(http.StatusServiceUnavailable, "learn queue is full, try again later")
+}
+
 // initChannels method will initialize learn and train channels.
 func (app *App) initChannels() {
-	learnChannels = make(map[string]chan string)
-	trainChannel = make(map[string]chan trainArgs)
+	learnChannels = make(map[string]chan learnRequest)
+	trainChannel = make(map[string]chan trainRequest)
+	learnQueues = make(map[string]*learnQueue)
+	trainQueues = make(map[string]*learnQueue)
+	learningsLocks = make(map[string]*sync.Mutex)
+	journals = make(map[string]*journal)
+
+	// Schemes of a language share the learnings DB
+	langLocks := make(map[string]*sync.Mutex)
 
 	for _, scheme := range schemeDetails {
-		learnChannels[scheme.Identifier] = make(chan string, defaultChanSize)
-		trainChannel[scheme.Identifier] = make(chan trainArgs, defaultChanSize)
+		size := getLearnQueueSize(scheme.Identifier)
 
-		handle, err := govarnamgo.InitFromID(scheme.Identifier)
+		learnChannels[scheme.Identifier] = make(chan learnRequest, size)
+		trainChannel[scheme.Identifier] = make(chan trainRequest, size)
+		learnQueues[scheme.Identifier] = &learnQueue{capacity: size}
+		trainQueues[scheme.Identifier] = &learnQueue{capacity: size}
+		if _, ok := langLocks[scheme.LangCode]; !ok {
+			langLocks[scheme.LangCode] = &sync.Mutex{}
+		}
+
+		learningsLocks[scheme.Identifier] = langLocks[scheme.LangCode]
+
+		j, err := openJournal(scheme.Identifier)
 		if err != nil {
-			app.log.Fatal("Unable to initialize varnam for lang", scheme.LangCode)
+			app.log.Fatal("Unable to open learn journal for lang", scheme.LangCode, err)
+		}
+
+		journals[scheme.Identifier] = j
+
+		// Every worker has its own handle and they share the channels of the scheme.
+		// Their writes to the learnings DB are serialized by lockLearnings.
+		for i := 0; i < getLearnWorkers(scheme.Identifier); i++ {
+			handle, err := govarnamgo.InitFromID(scheme.Identifier)
+			if err != nil {
+				app.log.Fatal("Unable to initialize varnam for lang", scheme.LangCode)
+			}
+
+			go app.listenForWords(scheme.Identifier, handle)
 		}
+	}
+}
+
+func getLearnWorkers(schemeIdentifier string) int {
+	if val, ok := learnWorkers[schemeIdentifier]; ok && val > 0 {
+		return val
+	}
 
-		go app.listenForWords(scheme.Identifier, handle)
+	if val := learnWorkers["default"]; val > 0 {
+		return val
 	}
+
+	return 1
+}
+
+// lockLearnings serializes writes to the learnings DB of a scheme. Handles
+// have their own connections to the DB, so concurrent writes from learner
+// workers and pooled handles would otherwise fail as the DB is busy.
+// It returns the function to unlock.
+func lockLearnings(schemeIdentifier string) func() {
+	mu, ok := learningsLocks[schemeIdentifier]
+	if !ok {
+		return func() {}
+	}
+
+	mu.Lock()
+
+	return mu.Unlock
 }
 
 func (app *App) listenForWords(lang string, handle *govarnamgo.VarnamHandle) {
+	j := journals[lang]
+
+	// Requests accepted before the last shutdown are applied first.
+	// Only one of the workers of a scheme gets them.
+	replay := j.takeReplay()
+	if len(replay) > 0 {
+		app.log.Printf("Replaying %d learn/train requests of %s from journal\n", len(replay), lang)
+	}
+
+	for _, e := range replay {
+		if e.Train != nil {
+			app.trainWord(lang, handle, *e.Train, e.User, nil)
+		} else {
+			app.learnBatch(lang, handle, []learnRequest{{Text: e.Learn, Weight: e.Weight, LearnedOn: e.LearnedOn, User: e.User}})
+		}
+
+		app.markApplied(lang, j, e.Seq)
+	}
+
 	for {
 		select {
-		case word := <-learnChannels[lang]:
-			if err := handle.Learn(strings.TrimSpace(word), 0); err != nil {
-				app.log.Printf("Failed to learn %s. %s\n", word, err.Error())
+		case req := <-learnChannels[lang]:
+			learnQueues[lang].release(1)
+
+			batch := collectLearnBatch(lang, req)
+			app.learnBatch(lang, handle, batch)
+
+			seqs := make([]uint64, len(batch))
+			for i, r := range batch {
+				seqs[i] = r.Seq
 			}
-		case args := <-trainChannel[lang]:
-			if err := handle.Train(strings.TrimSpace(args.Pattern), strings.TrimSpace(args.Word)); err != nil {
-				app.log.Printf("error training word: %s, pattern: %s, err:%s", args.Word, args.Pattern, err.Error())
+
+			app.markApplied(lang, j, seqs...)
+		case req := <-trainChannel[lang]:
+			trainQueues[lang].release(1)
+			app.trainWord(lang, handle, req.Args, req.User, req.Job)
+			app.markApplied(lang, j, req.Seq)
+		}
+	}
+}
+
+// collectLearnBatch takes the learn requests already waiting in the channel
+// along with req, up to learnBatchSize words.
+func collectLearnBatch(lang string, req learnRequest) []learnRequest {
+	var (
+		batch = []learnRequest{req}
+		words = len(strings.Fields(req.Text))
+	)
+
+	for words < learnBatchSize {
+		select {
+		case next := <-learnChannels[lang]:
+			learnQueues[lang].release(1)
+			batch = append(batch, next)
+			words += len(strings.Fields(next.Text))
+		default:
+			return batch
+		}
+	}
+
+	return batch
+}
+
+// learnBatch learns the words of all the requests with as few writes to the
+// learnings DB as possible. Requests with a timestamp are imported instead.
+func (app *App) learnBatch(lang string, handle *govarnamgo.VarnamHandle, batch []learnRequest) {
+	var (
+		pairs    []bigram
+		toLearn  []learnRequest
+		toImport []learnRequest
+	)
+
+	for _, req := range batch {
+		pairs = append(pairs, bigramsFromText(req.Text)...)
+
+		if err := recordContributions(lang, req.User, strings.Fields(req.Text)); err != nil {
+			app.log.Printf("Failed to record words learned by %s. %s\n", req.User, err.Error())
+		}
+
+		// Jobs without words are done when created
+		if len(strings.Fields(req.Text)) == 0 {
+			continue
+		}
+
+		if req.LearnedOn > 0 {
+			toImport = append(toImport, req)
+		} else {
+			toLearn = append(toLearn, req)
+		}
+	}
+
+	// Multi-word text is also learned as word pairs for predictions
+	if err := learnBigrams(lang, pairs); err != nil {
+		app.log.Printf("Failed to learn word pairs of %s. %s\n", lang, err.Error())
+	}
+
+	if len(toLearn) > 0 || len(toImport) > 0 {
+		defer invalidateLearnings(app.cache, lang)
+	}
+
+	if len(toLearn) == 1 && len(strings.Fields(toLearn[0].Text)) == 1 && toLearn[0].Weight != 1 {
+		req := toLearn[0]
+		word := strings.TrimSpace(req.Text)
+
+		unlock := lockLearnings(lang)
+		err := handle.Learn(word, learnWeight(req.Weight))
+		unlock()
+
+		if err != nil {
+			app.log.Printf("Failed to learn %s. %s\n", word, err.Error())
+			req.Job.progress(0, 1, fmt.Sprintf("Failed to learn %s. %s", word, err.Error()))
+		} else {
+			req.Job.progress(1, 0)
+		}
+	} else if len(toLearn) > 0 {
+		failed, err := learnWords(lang, handle, toLearn)
+		if err != nil {
+			app.log.Printf("Failed to learn words of %s. %s\n", lang, err.Error())
+		}
+
+		reportBatch(toLearn, failed, err)
+	}
+
+	if len(toImport) > 0 {
+		err := importWords(lang, handle, toImport)
+		if err != nil {
+			app.log.Printf("Failed to import words of %s. %s\n", lang, err.Error())
+		}
+
+		reportBatch(toImport, 0, err)
+	}
+}
+
+// learnWeight is the weight handle.Learn is given for a word to be stored with
+// weight w, the same as file learning and imports store it. Learn stores one
+// more than it's given, and its default weight when it's given 0. So a weight
+// of 1 can't be stored through Learn and such words are learned from a file.
+func learnWeight(w int) int {
+	if w <= 1 {
+		return 0
+	}
+
+	return w - 1
+}
+
+// reportBatch updates the jobs of a batch. govarnam only reports the number of words
+// it couldn't learn in a batch, so those are counted against the jobs in order.
+func reportBatch(batch []learnRequest, failed int, err error) {
+	for _, req := range batch {
+		n := len(strings.Fields(req.Text))
+
+		if err != nil {
+			req.Job.progress(0, n, fmt.Sprintf("Failed to learn. %s", err.Error()))
+			continue
+		}
+
+		f := failed
+		if f > n {
+			f = n
+		}
+
+		failed -= f
+
+		if f > 0 {
+			req.Job.progress(n-f, f, fmt.Sprintf("Failed to learn %d words", f))
+		} else {
+			req.Job.progress(n, 0)
+		}
+	}
+}
+
+// learnWords learns the words of the requests in one go through govarnam's file
+// learning, which inserts all of them with a single statement. govarnamgo doesn't
+// expose LearnMany, which takes the words directly, and file learning is the way
+// to it. The weight of a request is used for its words which are not learned yet.
+// It returns the number of words which couldn't be learned.
+func learnWords(lang string, handle *govarnamgo.VarnamHandle, batch []learnRequest) (int, error) {
+	file, err := ioutil.TempFile(os.TempDir(), "varnamd-learn-*.txt")
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	var (
+		w       = bufio.NewWriter(file)
+		skipped int
+	)
+
+	// Written as a frequency report so that words are read in pairs
+	// of word and weight. Numbers would be read as weights.
+	for _, req := range batch {
+		for _, word := range strings.Fields(req.Text) {
+			if _, err := strconv.Atoi(word); err == nil {
+				skipped++
+				continue
 			}
+
+			_, _ = fmt.Fprintf(w, "%s %d\n", word, req.Weight)
 		}
 	}
+
+	if err := w.Flush(); err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+
+	unlock := lockLearnings(lang)
+	defer unlock()
+
+	learnStatus, err := handle.LearnFromFile(file.Name())
+	if err != nil {
+		return 0, err
+	}
+
+	return learnStatus.FailedWords + skipped, nil
 }
 
-func learnWordsFromFile(c echo.Context, langCode string, fileToLearn string, removeFile bool) {
-	c.Response().WriteHeader(http.StatusOK)
+// importWords adds the words of the requests with their weight and timestamp through
+// govarnam's import, the same way packs are imported. Words which are already learned
+// are left as they are.
+func importWords(lang string, handle *govarnamgo.VarnamHandle, batch []learnRequest) error {
+	var words []map[string]interface{}
 
-	start := time.Now()
+	for _, req := range batch {
+		weight := req.Weight
+		if weight <= 0 {
+			weight = learnedWordMinWeight
+		}
 
-	sendOutput := func(msg string) {
-		_, _ = c.Response().Write([]byte(msg))
-		c.Response().Flush()
+		for _, word := range strings.Fields(req.Text) {
+			words = append(words, map[string]interface{}{"w": word, "c": weight, "l": req.LearnedOn})
+		}
 	}
 
-	sendOutput(fmt.Sprintf("Learning from %s\n", fileToLearn))
+	data, err := json.Marshal(map[string]interface{}{"words": words, "patterns": []interface{}{}})
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(os.TempDir(), "varnamd-import-*.json")
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	unlock := lockLearnings(lang)
+	defer unlock()
+
+	return handle.Import(file.Name())
+}
+
+func (app *App) trainWord(lang string, handle *govarnamgo.VarnamHandle, args trainArgs, user string, job *learnJob) {
+	if err := recordContributions(lang, user, []string{strings.TrimSpace(args.Word)}); err != nil {
+		app.log.Printf("Failed to record words learned by %s. %s\n", user, err.Error())
+	}
+
+	unlock := lockLearnings(lang)
+	err := handle.Train(strings.TrimSpace(args.Pattern), strings.TrimSpace(args.Word))
+	unlock()
+
+	invalidatePattern(app.cache, lang, strings.TrimSpace(args.Pattern))
+	invalidateLearnings(app.cache, lang)
+
+	if err != nil {
+		app.log.Printf("error training word: %s, pattern: %s, err:%s", args.Word, args.Pattern, err.Error())
+		job.progress(0, 1, fmt.Sprintf("error training word: %s, pattern: %s, err: %s", args.Word, args.Pattern, err.Error()))
+
+		return
+	}
+
+	job.progress(1, 0)
+}
+
+func (app *App) markApplied(lang string, j *journal, seqs ...uint64) {
+	if err := j.applied(seqs...); err != nil {
+		app.log.Printf("error checkpointing learn journal of %s, err: %s", lang, err.Error())
+	}
+}
+
+// learnFromFiles learns the files uploaded for a job, one after the other, and finishes the job.
+func (app *App) learnFromFiles(langCode string, files []string, job *learnJob) {
+	defer job.finish()
 
-	_, _ = getOrCreateHandler(langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+	for _, f := range files {
+		app.learnWordsFromFile(langCode, f, true, job)
+
+		// Each file is uploaded to a directory of its own
+		_ = os.Remove(filepath.Dir(f))
+	}
+}
+
+func (app *App) learnWordsFromFile(langCode string, fileToLearn string, removeFile bool, job *learnJob) {
+	start := time.Now()
+
+	app.log.Printf("learning from %s", fileToLearn)
+
+	_, err := getOrCreateHandler(context.Background(), langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+		unlock := lockLearnings(langCode)
 		learnStatus, verr := handle.LearnFromFile(fileToLearn)
+		unlock()
+
+		invalidateLearnings(app.cache, langCode)
+
 		end := time.Now()
 
 		if verr != nil {
-			sendOutput(fmt.Sprintf("Error learning: '%s'\n", verr.Error()))
+			app.log.Printf("error learning from %s, err: %s", fileToLearn, verr.Error())
+			job.add(0, 0, fmt.Sprintf("Error learning %s: %s", filepath.Base(fileToLearn), verr.Error()))
 		} else {
-			sendOutput(fmt.Sprintf("Finished Learning. TotalWords: %d, Failed: %d. Took %s\n", learnStatus.TotalWords, learnStatus.FailedWords, end.Sub(start)))
+			app.log.Printf("learned from %s. total words: %d, failed: %d. took %s", fileToLearn, learnStatus.TotalWords, learnStatus.FailedWords, end.Sub(start))
+			job.add(learnStatus.TotalWords, learnStatus.FailedWords)
 		}
 
-		if removeFile {
-			if err = os.Remove(fileToLearn); err != nil {
-				sendOutput(fmt.Sprintf("Error deleting '%s'. %s\n", fileToLearn, err.Error()))
-			}
+		if count, berr := learnBigramsFromFile(langCode, fileToLearn); berr != nil {
+			app.log.Printf("error learning word pairs from %s, err: %s", fileToLearn, berr.Error())
+			job.add(0, 0, fmt.Sprintf("Error learning word pairs from %s: %s", filepath.Base(fileToLearn), berr.Error()))
+		} else {
+			app.log.Printf("learned %d word pairs from %s", count, fileToLearn)
 		}
 
 		return
 	})
+	if err != nil {
+		app.log.Printf("error learning from %s, err: %s", fileToLearn, err.Error())
+		job.add(0, 0, fmt.Sprintf("Error learning %s: %s", filepath.Base(fileToLearn), err.Error()))
+	}
+
+	if removeFile {
+		if err := os.Remove(fileToLearn); err != nil {
+			app.log.Printf("error deleting %s, err: %s", fileToLearn, err.Error())
+		}
+	}
 }
 
 func importLearningsFromFile(c echo.Context, langCode string, fileToImport string, removeFile bool) error {
 	c.Response().WriteHeader(http.StatusOK)
 
-	start := time.Now()
+	var (
+		app   = c.Get("app").(*App)
+		start = time.Now()
+	)
 
 	sendOutput := func(msg string) {
 		_, _ = c.Response().Write([]byte(msg))
@@ -97,8 +534,13 @@ func importLearningsFromFile(c echo.Context, langCode string, fileToImport strin
 
 	var importError error
 
-	_, _ = getOrCreateHandler(langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+	_, _ = getOrCreateHandler(c.Request().Context(), langCode, func(handle *govarnamgo.VarnamHandle) (data interface{}, err error) {
+		unlock := lockLearnings(langCode)
 		err = handle.Import(fileToImport)
+		unlock()
+
+		invalidateLearnings(app.cache, langCode)
+
 		end := time.Now()
 
 		if err != nil {
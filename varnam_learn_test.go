@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// learnedWeight returns the weight of a learned word from the exact words of its pattern.
+func learnedWeight(t *testing.T, e *echo.Echo, pattern string, word string) (int, bool) {
+	t.Helper()
+
+	var resp advancedTransliterationResponse
+	decodeResponse(t, doRequest(t, e, http.MethodGet, "/atl/ml/"+pattern, nil), &resp)
+
+	for _, sug := range resp.ExactWords {
+		if sug.Word == word {
+			return sug.Weight, true
+		}
+	}
+
+	return 0, false
+}
+
+// Words are stored with the given weight whether they are learned one at a time or in a batch.
+func TestLearnWeight(t *testing.T) {
+	_, e := newTestServer(t)
+
+	cases := []struct {
+		name   string
+		text   string
+		weight int
+		// Pattern of each word of text
+		patterns []string
+		want     int
+	}{
+		{"single word", "മലക", 50, []string{"malaka"}, 50},
+		{"single word with default weight", "വനമ", 0, []string{"vanama"}, learnedWordMinWeight},
+		{"single word with weight 1", "പതക", 1, []string{"pataka"}, 1},
+		{"words", "കമല നമക", 40, []string{"kamala", "namaka"}, 40},
+		{"words with default weight", "സമര യമന", 0, []string{"samara", "yamana"}, learnedWordMinWeight},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var resp jobResponse
+			decodeResponse(t, doRequest(t, e, http.MethodPost, "/learn", learnArgs{LangCode: "ml", Text: c.text, Weight: c.weight}), &resp)
+			waitForJob(t, e, resp.JobID)
+
+			for i, word := range strings.Fields(c.text) {
+				weight, ok := learnedWeight(t, e, c.patterns[i], word)
+				if !ok {
+					t.Fatalf("%s isn't learned", word)
+				}
+
+				if weight != c.want {
+					t.Errorf("%s is learned with weight %d, expected %d", word, weight, c.want)
+				}
+			}
+		})
+	}
+}
+
+// A trained word is in the results of its pattern right away, even when the
+// learnings were invalidated just before and their invalidation is delayed.
+func TestTrainedWordIsTransliterated(t *testing.T) {
+	_, e := newTestServer(t)
+
+	trains := []trainArgs{
+		{Pattern: "kamalaa", Word: "കമലന"},
+		{Pattern: "samaraa", Word: "സമരക"},
+	}
+
+	for _, a := range trains {
+		// Cached before training
+		var tl transliterationResponse
+		decodeResponse(t, doRequest(t, e, http.MethodGet, "/tl/ml/"+a.Pattern, nil), &tl)
+		decodeResponse(t, doRequest(t, e, http.MethodGet, "/atl/ml/"+a.Pattern, nil), &advancedTransliterationResponse{})
+
+		var resp jobResponse
+		decodeResponse(t, doRequest(t, e, http.MethodPost, "/train/ml", a), &resp)
+		waitForJob(t, e, resp.JobID)
+
+		decodeResponse(t, doRequest(t, e, http.MethodGet, "/tl/ml/"+a.Pattern, nil), &tl)
+		if len(tl.Result) == 0 || tl.Result[0] != a.Word {
+			t.Errorf("expected %s first for %s in /tl, got %v", a.Word, a.Pattern, tl.Result)
+		}
+
+		var atl advancedTransliterationResponse
+		decodeResponse(t, doRequest(t, e, http.MethodGet, "/atl/ml/"+a.Pattern, nil), &atl)
+		if len(atl.ExactWords) == 0 || atl.ExactWords[0].Word != a.Word {
+			t.Errorf("expected %s in the exact words of %s in /atl, got %v", a.Word, a.Pattern, atl.ExactWords)
+		}
+	}
+}
+
+// A scheme's learner workers take requests while another worker waits to write to the learnings DB.
+func TestLearnWorkers(t *testing.T) {
+	_, e := newTestServer(t)
+
+	if getLearnWorkers("ml") < 2 {
+		t.Fatal("expected more than one learner worker for ml")
+	}
+
+	// Holds back the writes of the workers
+	unlock := lockLearnings("ml")
+	locked := true
+
+	defer func() {
+		if locked {
+			unlock()
+		}
+	}()
+
+	var (
+		texts    = []string{"ഗദയ", "ദസന"}
+		patterns = []string{"gadaya", "dasana"}
+		jobs     []string
+	)
+
+	for _, text := range texts {
+		var resp jobResponse
+		decodeResponse(t, doRequest(t, e, http.MethodPost, "/learn", learnArgs{LangCode: "ml", Text: text, Weight: 50}), &resp)
+		jobs = append(jobs, resp.JobID)
+
+		// Taken by a worker which isn't held back yet
+		deadline := time.Now().Add(5 * time.Second)
+		for learnQueues["ml"].len() > 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s wasn't taken while another worker was waiting", text)
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	unlock()
+	locked = false
+
+	for i, id := range jobs {
+		waitForJob(t, e, id)
+
+		if _, ok := learnedWeight(t, e, patterns[i], texts[i]); !ok {
+			t.Errorf("%s isn't learned", texts[i])
+		}
+	}
+}
+
+// Words learned from a file by sync show up in the results cached before.
+func TestLearnFromFileInvalidatesLearnings(t *testing.T) {
+	app, e := newTestServer(t)
+
+	// Cached before learning
+	if _, ok := learnedWeight(t, e, "ragava", "രഗവ"); ok {
+		t.Fatal("രഗവ is learned already")
+	}
+
+	f, err := ioutil.TempFile("", "varnamd-sync-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteString("രഗവ 50\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = f.Close()
+
+	learnFromFile(app.cache, "ml", f.Name())
+
+	// Invalidated once the interval since the last invalidation has passed
+	deadline := time.Now().Add(learningsInvalidateInterval + time.Second)
+	for {
+		if _, ok := learnedWeight(t, e, "ragava", "രഗവ"); ok {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected രഗവ in the results of ragava once the learnings are invalidated")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Uploaded files are learned in the background, tracked by the job returned right away.
+func TestLearnFileUpload(t *testing.T) {
+	_, e := newTestServer(t)
+
+	var body bytes.Buffer
+
+	w := multipart.NewWriter(&body)
+
+	fw, err := w.CreateFormFile("files", "words.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fw.Write([]byte("പരക 50\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Holds back the learning until the response is checked
+	unlock := lockLearnings("ml")
+	locked := true
+
+	defer func() {
+		if locked {
+			unlock()
+		}
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, "/learn/upload/ml", &body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+
+	var resp jobResponse
+	decodeResponse(t, serveRequest(e, req), &resp)
+
+	var status jobStatusResponse
+	decodeResponse(t, doRequest(t, e, http.MethodGet, "/jobs/"+resp.JobID, nil), &status)
+
+	if status.Job.Status != jobRunning {
+		t.Fatalf("expected the job to be running while the learnings are locked, got %s", status.Job.Status)
+	}
+
+	unlock()
+	locked = false
+
+	waitForJob(t, e, resp.JobID)
+
+	decodeResponse(t, doRequest(t, e, http.MethodGet, "/jobs/"+resp.JobID, nil), &status)
+
+	if status.Job.Total != 1 || status.Job.Applied != 1 {
+		t.Errorf("expected 1 word applied, got %d of %d", status.Job.Applied, status.Job.Total)
+	}
+
+	if _, ok := learnedWeight(t, e, "paraka", "പരക"); !ok {
+		t.Error("പരക isn't learned")
+	}
+}